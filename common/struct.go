@@ -6,7 +6,9 @@ import (
 	"github.com/bytedance/sonic"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // FieldInfo 存储struct中每个字段的信息
@@ -185,3 +187,102 @@ func GenerateSignString(input any, tag, sep, kvSep string, ignores []string, isS
 	}
 	return strings.Join(fields, sep)
 }
+
+// StructDefault 按标签给零值字段填充默认值，递归处理非nil的结构体指针与结构体切片
+func StructDefault(target any, tag string) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("target必须是结构体指针")
+	}
+	return structDefault(v.Elem(), tag)
+}
+
+func structDefault(v reflect.Value, tag string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.Struct:
+			if err := structDefault(fv, tag); err != nil {
+				return err
+			}
+			continue
+		case reflect.Ptr:
+			if !fv.IsNil() && fv.Elem().Kind() == reflect.Struct {
+				if err := structDefault(fv.Elem(), tag); err != nil {
+					return err
+				}
+			}
+			continue
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() == reflect.Struct {
+				for j := 0; j < fv.Len(); j++ {
+					if err := structDefault(fv.Index(j), tag); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+		value, ok := field.Tag.Lookup(tag)
+		if !ok || !fv.IsZero() {
+			continue
+		}
+		if err := setDefault(fv, value); err != nil {
+			return fmt.Errorf("%s默认值%q错误: %w", field.Name, value, err)
+		}
+	}
+	return nil
+}
+
+// setDefault 将字符串解析为字段类型并赋值
+func setDefault(fv reflect.Value, value string) error {
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if fv.Type() == reflect.TypeOf(time.Duration(0)) {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			fv.SetInt(int64(d))
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return errors.New("不支持的切片类型")
+		}
+		fv.Set(reflect.ValueOf(strings.Split(value, ",")).Convert(fv.Type()))
+	default:
+		return errors.New("不支持的类型")
+	}
+	return nil
+}
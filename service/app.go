@@ -0,0 +1,287 @@
+package service
+
+import (
+	"errors"
+	"github.com/go-co-op/gocron/v2"
+	"github.com/ipipdotnet/ipdb-go"
+	"github.com/orca-zhang/ecache"
+	"github.com/redis/go-redis/v9"
+	"github.com/sony/sonyflake"
+	"github.com/sundaqiang/sdq-go/common"
+	"github.com/valyala/fasthttp"
+	"go.mongodb.org/mongo-driver/mongo"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"gorm.io/gorm"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+App 应用容器，持有一份配置及由它初始化出的全部客户端
+
+	同一进程可以创建多个实例，但以下是进程级的
+	gin的参数校验器与翻译器只初始化一次，本地缓存的redis同步只有第一个开启的实例生效
+	包级函数操作默认实例并与全局变量同步，不能并发调用
+*/
+type App struct {
+	Cache   *ecache.Cache
+	Cron    gocron.Scheduler
+	Db      *gorm.DB
+	Http    *fasthttp.Client
+	Ipdb    *ipdb.City
+	Limiter *RedisRate
+	Log     *zap.Logger
+	Mdb     *mongo.Client
+	Rdb     []*redis.Client
+	Sony    *sonyflake.Sonyflake
+	// config 热更新时整体替换
+	config atomic.Pointer[Config]
+	// rdbDown 初始化时连接失败的Rdb索引，依赖它们的限流器和缓存降级
+	rdbDown map[int]bool
+
+	closers      []closer
+	closeMu      sync.Mutex
+	shutdownOnce sync.Once
+	shutdownErr  error
+
+	format      *ResponseFormat
+	docs        *OpenAPI
+	docsOnce    sync.Once
+	metrics     *Metrics
+	metricsOnce sync.Once
+	breakerMu   sync.Mutex
+	breakers    map[string]*breaker
+	// tracerProvider 未初始化链路追踪时为nil
+	tracerProvider *sdktrace.TracerProvider
+	level          zap.AtomicLevel
+	cacheCli       *GoRedisCli
+	loader         *configLoader
+	reloadMu       sync.Mutex
+	callbacks      map[string][]func()
+	proxy          atomic.Pointer[string]
+
+	healthMu sync.Mutex
+	health   []*healthCheck
+	optional map[string]bool
+	draining atomic.Bool
+}
+
+// std 默认实例，包级函数操作的都是它，全局变量是它的镜像
+var std = newApp(&Config{})
+
+// newApp 创建持有conf的实例
+func newApp(conf *Config) *App {
+	a := &App{}
+	a.config.Store(conf)
+	return a
+}
+
+/*
+NewApp 根据配置创建一个独立的应用实例
+
+	conf, err := service.LoadConfig("config.toml", "APP_", &userConf)
+	app, err := service.NewApp(conf, service.WithOptional(service.SubsystemMongo))
+*/
+func NewApp(conf *Config, opts ...ConfigOption) (*App, error) {
+	if conf == nil {
+		return nil, errors.New("缺少配置")
+	}
+	if err := ValidateConfig(conf); err != nil {
+		return nil, err
+	}
+	a := newApp(conf)
+	return a, a.setup(newConfigOptions(opts))
+}
+
+// NewAppFromFile 加载配置文件并创建独立实例，支持 WithWatch 热更新
+func NewAppFromFile(filePath, prefix string, conf any, opts ...ConfigOption) (*App, error) {
+	o := newConfigOptions(opts)
+	loader := newConfigLoader(filePath, prefix, conf, o)
+	target, err := loader.load()
+	if err != nil {
+		return nil, err
+	}
+	a := newApp(target)
+	a.loader = loader
+	return a, a.start(o)
+}
+
+// Default 获取默认实例
+func Default() *App {
+	return std
+}
+
+// Config 获取实例的配置，热更新后返回新的配置，已取得的配置不会再变化
+func (a *App) Config() *Config {
+	return a.config.Load()
+}
+
+// trace 链路id的键名
+func (a *App) trace() string {
+	server := a.Config().Server
+	if server == nil {
+		return ""
+	}
+	return server.Trace
+}
+
+// transEnabled 参数校验错误是否翻译
+func (a *App) transEnabled() bool {
+	server := a.Config().Server
+	return server != nil && server.Trans
+}
+
+// start 初始化各子系统，按需开启配置热更新
+func (a *App) start(o *configOptions) error {
+	err := a.setup(o)
+	a.Log.Info("生效配置", zap.Any("config", a.DumpConfig()))
+	if o.watch {
+		if watchErr := a.watch(); watchErr != nil {
+			err = errors.Join(err, watchErr)
+		}
+	}
+	return err
+}
+
+// setup 按配置初始化各子系统
+func (a *App) setup(o *configOptions) error {
+	conf := a.Config()
+	a.optional = o.optional
+	if a.Log == nil {
+		// 未配置日志或日志初始化失败时丢弃输出，各子系统无需判空
+		a.Log = zap.NewNop()
+	}
+	if conf.Log != nil {
+		// 日志失败时后续子系统无法记录，直接返回
+		if err := a.InitLogger(&lumberjack.Logger{
+			Filename:   conf.Log.Path + "/" + conf.Log.File,
+			MaxSize:    conf.Log.MaxSize,
+			MaxBackups: conf.Log.MaxBackups,
+			MaxAge:     conf.Log.MaxAge,
+			LocalTime:  conf.Log.LocalTime,
+			Compress:   conf.Log.Compress,
+		}, conf.Log.CallerSkip); err != nil {
+			return err
+		}
+		if err := a.SetLogLevel(conf.Log.Level); err != nil {
+			return newInitError(SubsystemLog, err)
+		}
+	}
+	var errs []error
+	check := func(err error) {
+		if err == nil {
+			return
+		}
+		var initErr *InitError
+		if errors.As(err, &initErr) && o.optional[initErr.Subsystem] {
+			a.Log.Warn("可选子系统初始化失败，降级启动", zap.Error(err))
+			return
+		}
+		errs = append(errs, err)
+	}
+	// 先于其他子系统初始化，启动阶段的调用也能记录
+	if conf.Tracing != nil {
+		check(a.InitTracing(conf.Tracing))
+	}
+	if conf.Database != nil {
+		if conf.Database.Gorm.Type != "" {
+			check(a.InitGORM(&conf.Database.Gorm))
+		}
+		if len(conf.Database.Redis) > 0 {
+			check(a.InitRdb(&conf.Database.Redis))
+		}
+		if conf.Database.Mongo.Url != "" {
+			check(a.InitMongo(&conf.Database.Mongo))
+		}
+	}
+	if conf.Other != nil {
+		if conf.Other.FastHttp {
+			a.InitFastHttp(conf.Other.ProxyAddr)
+		}
+		if conf.Other.Cron {
+			check(a.InitGoCron(conf.Other.CronAsync))
+		}
+		if conf.Other.SonyFlake > 0 {
+			check(a.InitSonyFlake(sonyflake.Settings{
+				StartTime: common.Timestamp2Time(conf.Other.SonyFlake, true),
+			}))
+		}
+		if conf.Other.IpdbPath != "" && conf.Other.IpdbCorn > 0 {
+			check(a.InitIpdb(conf.Other.IpdbPath, conf.Other.IpdbCorn))
+		}
+		if conf.Other.Limiter > -1 && len(a.Rdb) > 0 {
+			if a.rdbDown[conf.Other.Limiter] {
+				// redis的错误已经记录，不再重复报限流器失败
+				a.Log.Warn("限流器依赖的redis不可用，跳过初始化", zap.Int("rdb", conf.Other.Limiter))
+			} else {
+				check(a.InitLimit(conf.Other.Limiter))
+			}
+		}
+	}
+	a.setupCache()
+	return errors.Join(errs...)
+}
+
+// setupCache 按配置初始化本地缓存
+func (a *App) setupCache() {
+	conf := a.Config()
+	if conf.Cache != nil &&
+		conf.Cache.BucketCnt > 0 &&
+		conf.Cache.CapOne > 0 &&
+		conf.Cache.Expiration > 0 {
+		var rdb *redis.Client
+		if conf.Cache.Rdb > -1 && len(a.Rdb) > 0 && len(a.Rdb) > conf.Cache.Rdb {
+			if a.rdbDown[conf.Cache.Rdb] {
+				a.Log.Warn("本地缓存依赖的redis不可用，不同步其他实例", zap.Int("rdb", conf.Cache.Rdb))
+			} else {
+				rdb = a.Rdb[conf.Cache.Rdb]
+			}
+		}
+		a.InitLocalCache(
+			conf.Cache.BucketCnt,
+			conf.Cache.CapOne,
+			conf.Cache.CapTwo,
+			rdb,
+			conf.Cache.Size,
+			time.Duration(conf.Cache.Expiration)*time.Second)
+	}
+}
+
+// adopt 从全局变量读取，兼容直接给全局变量赋值的用法
+func (a *App) adopt() {
+	a.Cache = LRUCache
+	a.Cron = GoCron
+	a.Db = Db
+	a.Http = FastHttpClient
+	a.Ipdb = Ipdb
+	a.Limiter = Limiter
+	a.Log = ZapLog
+	a.Mdb = Mdb
+	a.Rdb = Rdb
+	a.Sony = SonyFlake
+}
+
+// publish 写回全局变量
+func (a *App) publish() {
+	LRUCache = a.Cache
+	GoCron = a.Cron
+	Db = a.Db
+	FastHttpClient = a.Http
+	Ipdb = a.Ipdb
+	Limiter = a.Limiter
+	ZapLog = a.Log
+	Mdb = a.Mdb
+	Rdb = a.Rdb
+	SonyFlake = a.Sony
+}
+
+// withStd 在默认实例上执行，前后与全局变量同步，没有加锁
+func withStd(fn func(a *App) error) error {
+	std.adopt()
+	err := fn(std)
+	std.publish()
+	return err
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// testConfig 日志写到临时目录，避免在包目录下生成日志文件
+func testConfig(t *testing.T, conf *Config) *Config {
+	t.Helper()
+	if conf.Log == nil {
+		conf.Log = &Log{Path: t.TempDir(), Level: "error"}
+	}
+	return conf
+}
+
+func TestOptionalRedisDegradesDependents(t *testing.T) {
+	conf := &Config{
+		Database: &Database{Redis: []Redis{{Addr: "127.0.0.1:1"}}},
+		Cache:    &Cache{},
+		Other:    &Other{},
+	}
+	a, err := NewApp(testConfig(t, conf), WithOptional(SubsystemRedis))
+	if err != nil {
+		t.Fatalf("redis可选时应降级启动: %v", err)
+	}
+	defer a.Shutdown(context.Background())
+	if a.Limiter != nil {
+		t.Fatal("依赖失败redis的限流器不应初始化")
+	}
+	if a.Cache == nil {
+		t.Fatal("本地缓存应继续可用")
+	}
+	if a.cacheCli != nil {
+		t.Fatal("本地缓存不应绑定失败的redis")
+	}
+}
+
+func TestRequiredRedisFails(t *testing.T) {
+	conf := &Config{Database: &Database{Redis: []Redis{{Addr: "127.0.0.1:1"}}}, Other: &Other{}}
+	a, err := NewApp(testConfig(t, conf))
+	var initErr *InitError
+	if !errors.As(err, &initErr) || initErr.Subsystem != SubsystemRedis {
+		t.Fatalf("应返回redis的初始化错误: %v", err)
+	}
+	if strings.Contains(err.Error(), SubsystemLimiter) {
+		t.Fatalf("不应重复报限流器失败: %v", err)
+	}
+	_ = a.Shutdown(context.Background())
+}
+
+func TestCacheSyncBindsOneApp(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+	defer rdb.Close()
+	newCacheApp := func() *App {
+		a, err := NewApp(testConfig(t, &Config{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.InitLocalCache(1, 8, 0, rdb, 10, 0)
+		return a
+	}
+	first, second := newCacheApp(), newCacheApp()
+	defer second.Shutdown(context.Background())
+	if first.cacheCli == nil {
+		t.Fatal("第一个实例应开启redis同步")
+	}
+	if second.cacheCli != nil || second.Cache == nil {
+		t.Fatal("其他实例只使用本地缓存，不应接管进程级的同步")
+	}
+
+	_ = first.Shutdown(context.Background())
+	third := newCacheApp()
+	defer third.Shutdown(context.Background())
+	if third.cacheCli == nil {
+		t.Fatal("开启同步的实例关闭后，其他实例可以开启")
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/sundaqiang/sdq-go/common"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+var (
+	configValidate     *validator.Validate
+	configValidateOnce sync.Once
+)
+
+// ConfigError 配置校验错误，列出所有不合法的键
+type ConfigError struct {
+	Fields []ConfigFieldError
+}
+
+// ConfigFieldError 单个键的校验错误
+type ConfigFieldError struct {
+	Key   string // 键路径，如 database.redis[1].addr
+	Rule  string // 未通过的规则，如 min=1
+	Value any    // 实际的值
+}
+
+func (e *ConfigError) Error() string {
+	var b strings.Builder
+	b.WriteString("配置校验失败:")
+	for _, v := range e.Fields {
+		b.WriteString(fmt.Sprintf("\n  %s: 值 %v 不满足 %s", v.Key, v.Value, v.Rule))
+	}
+	return b.String()
+}
+
+// getConfigValidate 独立于gin的校验器，字段名使用toml标签以便输出键路径
+func getConfigValidate() *validator.Validate {
+	configValidateOnce.Do(func() {
+		configValidate = validator.New(validator.WithRequiredStructEnabled())
+		configValidate.RegisterTagNameFunc(func(fld reflect.StructField) string {
+			name := strings.SplitN(fld.Tag.Get("toml"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+		configValidate.RegisterStructValidation(validateConfigIndex, Config{})
+		configValidate.RegisterStructValidation(validateCors, Cors{})
+	})
+	return configValidate
+}
+
+// validateConfigIndex 限流器与缓存引用的redis索引必须存在
+func validateConfigIndex(sl validator.StructLevel) {
+	conf := sl.Current().Interface().(Config)
+	if conf.Database == nil || len(conf.Database.Redis) == 0 {
+		return
+	}
+	n := len(conf.Database.Redis)
+	if conf.Other != nil && conf.Other.Limiter >= n {
+		sl.ReportError(conf.Other.Limiter, "other.limiter", "Limiter", "lt=len(database.redis)", "")
+	}
+	if conf.Cache != nil && conf.Cache.Rdb >= n {
+		sl.ReportError(conf.Cache.Rdb, "cache.rdb", "Rdb", "lt=len(database.redis)", "")
+	}
+}
+
+// validateCors 允许携带凭证时必须列出具体的来源，否则任意网站都能带着用户的cookie跨域读取
+func validateCors(sl validator.StructLevel) {
+	conf := sl.Current().Interface().(Cors)
+	if !conf.Enable || !conf.AllowCredentials {
+		return
+	}
+	for _, v := range conf.AllowOrigins {
+		if v == "*" {
+			sl.ReportError(conf.AllowOrigins, "allow-origins", "AllowOrigins", "excluded_with", "allow-credentials")
+			return
+		}
+	}
+}
+
+/*
+ValidateConfig 填充默认值并校验配置
+
+	字段通过 default 标签声明默认值，validate 标签声明规则
+	省略的[server]、[log]、[other]按空段处理，同样填充默认值并校验，省略[other]时不开启限流器
+	所有不合法的键一次性以 *ConfigError 返回
+*/
+func ValidateConfig(conf *Config) error {
+	if conf.Server == nil {
+		conf.Server = &Server{}
+	}
+	if conf.Log == nil {
+		conf.Log = &Log{}
+	}
+	if conf.Other == nil {
+		conf.Other = &Other{Limiter: -1}
+	}
+	if err := common.StructDefault(conf, "default"); err != nil {
+		return err
+	}
+	return configError(getConfigValidate().Struct(conf), "")
+}
+
+// validateMiddleware 校验通过 GinOption 传入的中间件配置
+func validateMiddleware(mw *Middleware) error {
+	return configError(getConfigValidate().Struct(mw), "server.middleware.")
+}
+
+// configError 把校验错误转为 *ConfigError，键路径去掉顶层结构体并加上prefix
+func configError(err error, prefix string) error {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	configErr := &ConfigError{}
+	for _, v := range errs {
+		rule := v.Tag()
+		if v.Param() != "" {
+			rule += "=" + v.Param()
+		}
+		configErr.Fields = append(configErr.Fields, ConfigFieldError{
+			// 去掉顶层的Config
+			Key:   prefix + v.Namespace()[strings.Index(v.Namespace(), ".")+1:],
+			Rule:  rule,
+			Value: v.Value(),
+		})
+	}
+	return configErr
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateConfigFillsOmittedSections(t *testing.T) {
+	conf := &Config{}
+	if err := ValidateConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Server == nil || conf.Server.Port != 8080 || conf.Server.Readyz != "/readyz" {
+		t.Fatalf("省略的[server]应填充默认值: %+v", conf.Server)
+	}
+	if conf.Log == nil || conf.Log.Path != "./logs" || conf.Log.MaxSize != 100 {
+		t.Fatalf("省略的[log]应填充默认值: %+v", conf.Log)
+	}
+	if conf.Other == nil || conf.Other.Limiter != -1 {
+		t.Fatalf("省略的[other]不应开启限流器: %+v", conf.Other)
+	}
+	if conf.Database != nil || conf.Cache != nil || conf.Tracing != nil {
+		t.Fatal("可选的子系统段不应被创建")
+	}
+}
+
+func TestValidateConfigReportsAllKeys(t *testing.T) {
+	err := ValidateConfig(&Config{
+		Server: &Server{Port: 70000},
+		Log:    &Log{Level: "verbose"},
+		Other:  &Other{Limiter: -2},
+	})
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) {
+		t.Fatalf("应返回*ConfigError: %v", err)
+	}
+	keys := map[string]bool{}
+	for _, v := range configErr.Fields {
+		keys[v.Key] = true
+	}
+	for _, k := range []string{"server.port", "log.level", "other.limiter"} {
+		if !keys[k] {
+			t.Fatalf("缺少%s: %v", k, err)
+		}
+	}
+}
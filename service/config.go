@@ -2,16 +2,9 @@ package service
 
 import (
 	"errors"
-	"github.com/knadh/koanf/parsers/toml"
-	"github.com/knadh/koanf/providers/env"
-	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
-	"github.com/redis/go-redis/v9"
-	"github.com/sony/sonyflake"
 	"github.com/sundaqiang/sdq-go/common"
-	"gopkg.in/natefinch/lumberjack.v2"
-	"strings"
-	"time"
+	"reflect"
 )
 
 type Config struct {
@@ -20,132 +13,229 @@ type Config struct {
 	Database *Database `toml:"database"`
 	Cache    *Cache    `toml:"cache"`
 	Other    *Other    `toml:"other"`
+	Tracing  *Tracing  `toml:"tracing"`
 }
 
 type Database struct {
 	Gorm  Gorm    `toml:"gorm"`
-	Redis []Redis `toml:"redis"`
+	Redis []Redis `toml:"redis" validate:"dive"`
 	Mongo Mongo   `toml:"mongo"`
 }
 
 type Server struct {
-	Name  string `toml:"name"`
-	Host  string `toml:"host"`
-	Port  int    `toml:"port"`
-	Trace string `toml:"trace"`
-	Trans bool   `toml:"trans"`
+	Name            string     `toml:"name"`
+	Host            string     `toml:"host" validate:"omitempty,ip"`
+	Port            int        `toml:"port" default:"8080" validate:"min=1,max=65535"`
+	Trace           string     `toml:"trace" default:"trace_id"`
+	Trans           bool       `toml:"trans"`
+	ShutdownTimeout int64      `toml:"shutdown-timeout" default:"10" validate:"min=0"`
+	DevConfig       bool       `toml:"dev-config"`
+	HealthTimeout   int64      `toml:"health-timeout" default:"3" validate:"min=0"`
+	Healthz         string     `toml:"healthz" default:"/healthz" validate:"startswith=/|eq=-"` // 存活检查路径，-不注册
+	Readyz          string     `toml:"readyz" default:"/readyz" validate:"startswith=/|eq=-"`   // 就绪检查路径，-不注册
+	HealthDetail    bool       `toml:"health-detail"`                                           // 就绪检查返回错误详情，默认只返回每项是否正常
+	CertFile        string     `toml:"cert-file" validate:"required_with=KeyFile"`
+	KeyFile         string     `toml:"key-file" validate:"required_with=CertFile"`
+	ClientCa        string     `toml:"client-ca" validate:"excluded_without=CertFile"`
+	TlsMinVersion   string     `toml:"tls-min-version" default:"1.2" validate:"oneof=1.0 1.1 1.2 1.3"`
+	H2c             bool       `toml:"h2c"`
+	Docs            string     `toml:"docs" validate:"omitempty,startswith=/"`
+	Metrics         string     `toml:"metrics" validate:"omitempty,startswith=/"`
+	Middleware      Middleware `toml:"middleware"`
 }
 
 type Log struct {
-	Path       string `toml:"path"`
-	File       string `toml:"file"`
-	MaxSize    int    `toml:"max-size"`
-	MaxBackups int    `toml:"max-backups"`
-	MaxAge     int    `toml:"max-age"`
+	Path       string `toml:"path" default:"./logs"`
+	File       string `toml:"file" default:"app"`
+	MaxSize    int    `toml:"max-size" default:"100" validate:"min=1"`
+	MaxBackups int    `toml:"max-backups" default:"30" validate:"min=0"`
+	MaxAge     int    `toml:"max-age" default:"7" validate:"min=0"`
 	LocalTime  bool   `toml:"local-time"`
 	Compress   bool   `toml:"compress"`
-	CallerSkip int    `toml:"caller-skip"`
+	CallerSkip int    `toml:"caller-skip" validate:"min=0"`
+	Level      string `toml:"level" validate:"omitempty,oneof=debug info warn error dpanic panic fatal"`
 }
 
 type Cache struct {
-	BucketCnt  uint16 `toml:"bucket-cnt"`
-	CapOne     uint16 `toml:"cap-one"`
+	BucketCnt  uint16 `toml:"bucket-cnt" default:"256" validate:"min=1"`
+	CapOne     uint16 `toml:"cap-one" default:"1024" validate:"min=1"`
 	CapTwo     uint16 `toml:"cap-two"`
-	Rdb        int    `toml:"rdb"`
-	Size       int    `toml:"size"`
-	Expiration int64  `toml:"expiration"`
+	Rdb        int    `toml:"rdb" validate:"min=-1"`
+	Size       int    `toml:"size" default:"100" validate:"min=1"`
+	Expiration int64  `toml:"expiration" default:"300" validate:"min=1"`
 }
 
 type Other struct {
 	FastHttp  bool   `toml:"fast-http"`
-	ProxyAddr string `toml:"proxy-addr"`
+	ProxyAddr string `toml:"proxy-addr" validate:"omitempty,hostname_port"`
 	Cron      bool   `toml:"cron"`
 	CronAsync bool   `toml:"cron-async"`
-	SonyFlake int64  `toml:"sony-flake"`
+	SonyFlake int64  `toml:"sony-flake" validate:"min=0"`
 	IpdbPath  string `toml:"ipdb-path"`
-	IpdbCorn  int64  `toml:"ipdb-corn"`
-	Limiter   int    `toml:"limiter"`
+	IpdbCorn  int64  `toml:"ipdb-corn" validate:"min=0"`
+	Limiter   int    `toml:"limiter" validate:"min=-1"`
 }
 
-var k = koanf.New(".")
+// ConfigOption InitConfig 的可选项
+type ConfigOption func(o *configOptions)
 
-func InitConfig(filePath, prefix string, conf any) error {
-	if filePath != "" {
-		if err := k.Load(file.Provider(filePath), toml.Parser()); err != nil {
-			return err
+type configOptions struct {
+	optional     map[string]bool
+	watch        bool
+	sources      []ConfigSource
+	tag          string
+	secretKeyEnv string
+}
+
+/*
+WithOptional 将子系统标记为可选，初始化失败时仅记录警告并继续启动
+
+	service.InitConfig("config.toml", "APP_", &conf, service.WithOptional(service.SubsystemMongo))
+*/
+func WithOptional(subsystems ...string) ConfigOption {
+	return func(o *configOptions) {
+		for _, v := range subsystems {
+			o.optional[v] = true
 		}
 	}
-	if prefix != "" {
-		if err := k.Load(env.ProviderWithValue(prefix, ".", func(s string, v string) (string, interface{}) {
-			key := strings.Replace(strings.ToLower(strings.TrimPrefix(s, prefix)), "_", ".", -1)
-			if strings.Contains(v, " ") {
-				return key, strings.Split(v, " ")
-			}
-			return key, v
-		}), nil); err != nil {
-			return err
-		}
+}
+
+// WithWatch 监听配置文件，变更后自动重新加载并触发 OnConfigChange 注册的回调
+func WithWatch() ConfigOption {
+	return func(o *configOptions) {
+		o.watch = true
+	}
+}
+
+/*
+WithSources 追加配置来源，位于filePath之后，按顺序合并
+
+	prefix环境变量默认最后加载，来源中包含 EnvSource(prefix) 时按它的位置加载
+	service.InitConfig("base.toml", "APP_", &conf, service.WithSources(
+		service.FileSource("prod.yaml"),
+		service.EnvSource("APP_"),
+		service.FlagSource(flag.CommandLine), // 命令行参数覆盖环境变量
+	))
+*/
+func WithSources(sources ...ConfigSource) ConfigOption {
+	return func(o *configOptions) {
+		o.sources = append(o.sources, sources...)
+	}
+}
+
+// WithTag 解析用户结构体使用的标签，默认toml
+func WithTag(tag string) ConfigOption {
+	return func(o *configOptions) {
+		o.tag = tag
+	}
+}
+
+func newConfigOptions(opts []ConfigOption) *configOptions {
+	o := &configOptions{optional: map[string]bool{}, tag: "toml", secretKeyEnv: DefaultSecretKeyEnv}
+	for _, opt := range opts {
+		opt(o)
 	}
-	err := k.UnmarshalWithConf("", conf, koanf.UnmarshalConf{Tag: "toml"})
+	return o
+}
+
+// InitConfig 加载配置并初始化默认实例的各子系统，返回所有必选子系统的错误合集
+func InitConfig(filePath, prefix string, conf any, opts ...ConfigOption) error {
+	o := newConfigOptions(opts)
+	loader := newConfigLoader(filePath, prefix, conf, o)
+	target, err := loader.load()
 	if err != nil {
 		return err
 	}
-	common.StructAssign(&config, conf, "toml")
-	if config.Log != nil && !InitLogger(&lumberjack.Logger{
-		Filename:   config.Log.Path + "/" + config.Log.File,
-		MaxSize:    config.Log.MaxSize,
-		MaxBackups: config.Log.MaxBackups,
-		MaxAge:     config.Log.MaxAge,
-		LocalTime:  config.Log.LocalTime,
-		Compress:   config.Log.Compress,
-	}, config.Log.CallerSkip) {
-		return errors.New("初始化日志失败")
-	}
-	if config.Database != nil {
-		if config.Database.Gorm.Type != "" {
-			InitGORM(&config.Database.Gorm)
-		}
-		if len(config.Database.Redis) > 0 {
-			InitRdb(&config.Database.Redis)
-		}
-		if config.Database.Mongo.Url != "" {
-			InitMongo(&config.Database.Mongo)
-		}
+	return withStd(func(a *App) error {
+		a.loader = loader
+		a.config.Store(target)
+		return a.start(o)
+	})
+}
+
+// LoadConfig 只加载配置不初始化，配合 NewApp 创建独立实例
+func LoadConfig(filePath, prefix string, conf any, opts ...ConfigOption) (*Config, error) {
+	loader := newConfigLoader(filePath, prefix, conf, newConfigOptions(opts))
+	return loader.load()
+}
+
+// configLoader 记录配置的来源，热更新时按同样的方式重新加载
+type configLoader struct {
+	sources      []ConfigSource
+	tag          string
+	secretKeyEnv string
+	conf         any
+	k            *koanf.Koanf
+	secrets      map[string]bool // 由密钥引用解析出的键路径，输出时脱敏
+}
+
+// newConfigLoader filePath最先加载，prefix环境变量未在 WithSources 中指定位置时最后加载
+func newConfigLoader(filePath, prefix string, conf any, o *configOptions) *configLoader {
+	var sources []ConfigSource
+	if filePath != "" {
+		sources = append(sources, FileSource(filePath))
 	}
-	if config.Other != nil {
-		if config.Other.FastHttp {
-			InitFastHttp(config.Other.ProxyAddr)
-		}
-		if config.Other.Cron {
-			InitGoCron(config.Other.CronAsync)
-		}
-		if config.Other.SonyFlake > 0 {
-			InitSonyFlake(sonyflake.Settings{
-				StartTime: common.Timestamp2Time(config.Other.SonyFlake, true),
-			})
-		}
-		if config.Other.IpdbPath != "" && config.Other.IpdbCorn > 0 {
-			InitIpdb(config.Other.IpdbPath, config.Other.IpdbCorn)
-		}
-		if config.Other.Limiter > -1 && len(Rdb) > 0 && len(Rdb) > config.Other.Limiter {
-			InitLimit(config.Other.Limiter)
+	placed := false
+	for _, v := range o.sources {
+		sources = append(sources, v)
+		placed = placed || (prefix != "" && v.prefix == prefix)
+	}
+	if prefix != "" && !placed {
+		sources = append(sources, EnvSource(prefix))
+	}
+	return &configLoader{sources: sources, tag: o.tag, secretKeyEnv: o.secretKeyEnv, conf: conf}
+}
+
+// files 所有配置文件，用于热更新监听
+func (l *configLoader) files() []string {
+	var files []string
+	for _, v := range l.sources {
+		if v.path != "" {
+			files = append(files, v.path)
 		}
 	}
-	if config.Cache != nil &&
-		config.Cache.BucketCnt > 0 &&
-		config.Cache.CapOne > 0 &&
-		config.Cache.Expiration > 0 {
-		var rdb *redis.Client
-		if config.Cache.Rdb > -1 && len(Rdb) > 0 && len(Rdb) > config.Cache.Rdb {
-			rdb = Rdb[config.Cache.Rdb]
+	return files
+}
+
+/*
+load 依次合并所有来源，解析到新的用户结构体并复制到新的Config
+
+	校验通过后才写回用户结构体，失败时正在使用的配置保持不变
+	热更新时由 reloadMu 保护，用户结构体按配置重新生成，之前手动赋值的字段会被覆盖
+*/
+func (l *configLoader) load() (*Config, error) {
+	dst := reflect.ValueOf(l.conf)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return nil, errors.New("conf必须是非nil的结构体指针")
+	}
+	user := reflect.New(dst.Type().Elem())
+	k := koanf.New(".")
+	for _, v := range l.sources {
+		if err := v.load(k, user.Interface(), l.tag); err != nil {
+			return nil, err
 		}
-		InitLocalCache(
-			config.Cache.BucketCnt,
-			config.Cache.CapOne,
-			config.Cache.CapTwo,
-			rdb,
-			config.Cache.Size,
-			time.Duration(config.Cache.Expiration)*time.Second)
-	}
-	return nil
+	}
+	// 在解析到结构体之前替换密钥引用
+	k, secrets, err := resolveSecrets(k, l.secretKeyEnv)
+	if err != nil {
+		return nil, err
+	}
+	err = k.UnmarshalWithConf("", user.Interface(), koanf.UnmarshalConf{Tag: l.tag})
+	if err != nil {
+		return nil, err
+	}
+	target := &Config{}
+	if l.tag == "toml" {
+		common.StructAssign(target, user.Interface(), l.tag)
+	} else if err = k.UnmarshalWithConf("", target, koanf.UnmarshalConf{Tag: "toml"}); err != nil {
+		// StructAssign依赖两边相同的标签，自定义标签时内置配置直接按toml标签解析
+		return nil, err
+	}
+	if err = ValidateConfig(target); err != nil {
+		return nil, err
+	}
+	dst.Elem().Set(user.Elem())
+	l.k = k
+	l.secrets = secrets
+	return target, nil
 }
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testUserConf 用户配置结构体，嵌入内置配置的段
+type testUserConf struct {
+	Server *Server `toml:"server"`
+	Log    *Log    `toml:"log"`
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigSourceOrder(t *testing.T) {
+	path := writeConfigFile(t, "[server]\nport = 8000\n")
+	t.Setenv("SDQTEST_SERVER_PORT", "9000")
+	newFlags := func() *flag.FlagSet {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Int("server.port", 0, "")
+		if err := fs.Parse([]string{"-server.port=9100"}); err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	}
+
+	cases := []struct {
+		name    string
+		sources []ConfigSource
+		port    int
+	}{
+		{"环境变量默认最后加载", []ConfigSource{FlagSource(newFlags())}, 9000},
+		{"按指定的位置加载环境变量", []ConfigSource{EnvSource("SDQTEST_"), FlagSource(newFlags())}, 9100},
+		{"没有其他来源", nil, 9000},
+	}
+	for _, c := range cases {
+		var user testUserConf
+		conf, err := LoadConfig(path, "SDQTEST_", &user, WithSources(c.sources...))
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if conf.Server.Port != c.port || user.Server.Port != c.port {
+			t.Fatalf("%s: port = %d, user = %d", c.name, conf.Server.Port, user.Server.Port)
+		}
+	}
+}
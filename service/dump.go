@@ -0,0 +1,28 @@
+package service
+
+import "reflect"
+
+// DumpConfig 默认实例脱敏后的生效配置
+func DumpConfig() map[string]any {
+	return std.DumpConfig()
+}
+
+/*
+DumpConfig 脱敏后的生效配置，包含合并所有来源之后的内置配置与用户结构体
+
+	{"config": {...}, "user": {...}}
+*/
+func (a *App) DumpConfig() map[string]any {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	var secrets map[string]bool
+	res := map[string]any{}
+	if a.loader != nil {
+		secrets = a.loader.secrets
+		if a.loader.conf != nil {
+			res["user"] = redactValue(reflect.ValueOf(a.loader.conf), "", a.loader.tag, secrets, false)
+		}
+	}
+	res["config"] = Redact(a.Config(), secrets)
+	return res
+}
@@ -8,6 +8,7 @@ import (
 
 type GoRedisCli struct {
 	ctx      context.Context
+	cancel   context.CancelFunc
 	redisCli *redis.Client
 	chanSize int
 }
@@ -23,7 +24,11 @@ func (g *GoRedisCli) Pub(channel, payload string) error {
 }
 
 func (g *GoRedisCli) Sub(channel string, callback func(payload string)) error {
-	msgChan := g.redisCli.Subscribe(g.ctx, channel).Channel(redis.WithChannelSize(g.chanSize))
+	pubSub := g.redisCli.Subscribe(g.ctx, channel)
+	defer func(pubSub *redis.PubSub) {
+		_ = pubSub.Close()
+	}(pubSub)
+	msgChan := pubSub.Channel(redis.WithChannelSize(g.chanSize))
 	for {
 		select {
 		case msg, ok := <-msgChan:
@@ -31,19 +36,32 @@ func (g *GoRedisCli) Sub(channel string, callback func(payload string)) error {
 				return nil
 			}
 			callback(msg.Payload)
-		default:
+		case <-g.ctx.Done():
+			return nil
 		}
 	}
 }
 
+// Close 取消订阅
+func (g *GoRedisCli) Close() error {
+	g.cancel()
+	return nil
+}
+
 func Take(r *redis.Client, size ...int) dist.RedisCli {
 	s := 100 // default 100 messages
 	if len(size) > 0 {
 		s = size[0]
 	}
+	return newGoRedisCli(r, s)
+}
+
+func newGoRedisCli(r *redis.Client, size int) *GoRedisCli {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &GoRedisCli{
-		ctx:      context.TODO(),
+		ctx:      ctx,
+		cancel:   cancel,
 		redisCli: r,
-		chanSize: s,
+		chanSize: size,
 	}
 }
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"fmt"
+	"github.com/knadh/koanf/v2"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPart 环境变量解析出的一段键路径，index>-1时表示切片下标
+type envPart struct {
+	key   string
+	index int
+}
+
+/*
+loadEnv 加载以prefix开头的环境变量
+
+	按用户结构体解析键路径，键名中的-写作_，切片用下标表示
+	APP_LOG_MAX_SIZE=10          -> log.max-size
+	APP_DATABASE_REDIS_1_ADDR=.. -> database.redis[1].addr
+	值按目标字段的类型转换，切片字段以逗号分隔
+	无法匹配字段的变量按_转.处理
+*/
+func loadEnv(k *koanf.Koanf, prefix string, conf any, tag string) error {
+	raw := k.Raw()
+	var typ reflect.Type
+	if conf != nil {
+		typ = reflect.TypeOf(conf)
+	}
+	for _, kv := range os.Environ() {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		tokens := strings.Split(strings.Trim(strings.ToLower(strings.TrimPrefix(name, prefix)), "_"), "_")
+		if len(tokens) == 1 && tokens[0] == "" {
+			continue
+		}
+		parts, ft, matched := matchEnvPath(typ, tokens, tag)
+		if !matched {
+			parts = parts[:0]
+			for _, v := range tokens {
+				parts = append(parts, envPart{key: v, index: -1})
+			}
+			raw = setEnvValue(raw, parts, value).(map[string]interface{})
+			continue
+		}
+		v, err := coerceEnv(value, ft)
+		if err != nil {
+			return fmt.Errorf("环境变量%s: %w", name, err)
+		}
+		raw = setEnvValue(raw, parts, v).(map[string]interface{})
+	}
+	// raw包含之前的全部数据，合并后切片以raw中的为准
+	return k.Load(mapProvider(raw), nil)
+}
+
+// matchEnvPath 在结构体中匹配键路径，返回路径与叶子字段类型
+func matchEnvPath(t reflect.Type, tokens []string, tag string) ([]envPart, reflect.Type, bool) {
+	if t == nil {
+		return nil, nil, false
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if len(tokens) == 0 {
+		return nil, t, true
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		// 优先匹配最长的键，兼容键名本身含有-或_
+		for n := len(tokens); n > 0; n-- {
+			candidate := strings.Join(tokens[:n], "_")
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				if !field.IsExported() {
+					continue
+				}
+				key := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+				if key == "-" {
+					continue
+				}
+				if key == "" {
+					key = strings.ToLower(field.Name)
+				}
+				if strings.ReplaceAll(strings.ToLower(key), "-", "_") != candidate {
+					continue
+				}
+				parts, ft, ok := matchEnvPath(field.Type, tokens[n:], tag)
+				if ok {
+					return append([]envPart{{key: key, index: -1}}, parts...), ft, true
+				}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		index, err := strconv.Atoi(tokens[0])
+		if err != nil || index < 0 {
+			return nil, nil, false
+		}
+		parts, ft, ok := matchEnvPath(t.Elem(), tokens[1:], tag)
+		if ok {
+			return append([]envPart{{index: index}}, parts...), ft, true
+		}
+	case reflect.Map:
+		parts, ft, ok := matchEnvPath(t.Elem(), tokens[1:], tag)
+		if ok {
+			return append([]envPart{{key: tokens[0], index: -1}}, parts...), ft, true
+		}
+	}
+	return nil, nil, false
+}
+
+// coerceEnv 按字段类型转换环境变量的值
+func coerceEnv(value string, t reflect.Type) (any, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if t == reflect.TypeOf(time.Duration(0)) {
+			return time.ParseDuration(value)
+		}
+		return strconv.ParseInt(value, 10, t.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(value, 10, t.Bits())
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, t.Bits())
+	case reflect.Slice, reflect.Array:
+		if value == "" {
+			return []interface{}{}, nil
+		}
+		items := strings.Split(value, ",")
+		res := make([]interface{}, len(items))
+		for i, v := range items {
+			item, err := coerceEnv(strings.TrimSpace(v), t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			res[i] = item
+		}
+		return res, nil
+	}
+	return value, nil
+}
+
+// setEnvValue 按路径写入值，途经的map与切片会复制后再修改
+func setEnvValue(node any, parts []envPart, value any) any {
+	if len(parts) == 0 {
+		return value
+	}
+	part := parts[0]
+	if part.index > -1 {
+		src, _ := node.([]interface{})
+		dst := make([]interface{}, len(src), max(len(src), part.index+1))
+		copy(dst, src)
+		for len(dst) <= part.index {
+			dst = append(dst, map[string]interface{}{})
+		}
+		dst[part.index] = setEnvValue(dst[part.index], parts[1:], value)
+		return dst
+	}
+	src, _ := node.(map[string]interface{})
+	dst := make(map[string]interface{}, len(src)+1)
+	for k, v := range src {
+		dst[k] = v
+	}
+	dst[part.key] = setEnvValue(dst[part.key], parts[1:], value)
+	return dst
+}
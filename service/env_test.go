@@ -0,0 +1,106 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/koanf/v2"
+)
+
+type envTestConf struct {
+	Log      *Log      `toml:"log"`
+	Database *Database `toml:"database"`
+	Tracing  *Tracing  `toml:"tracing"`
+	App      struct {
+		Tags    []string      `toml:"tags"`
+		Ports   []int         `toml:"ports"`
+		Timeout time.Duration `toml:"timeout"`
+		Debug   bool          `toml:"debug"`
+	} `toml:"app"`
+}
+
+// loadTestEnv 在base之上加载SDQENV_开头的环境变量并解析
+func loadTestEnv(t *testing.T, base map[string]any) (*envTestConf, *koanf.Koanf, error) {
+	t.Helper()
+	k := koanf.New(".")
+	if err := k.Load(mapProvider(base), nil); err != nil {
+		t.Fatal(err)
+	}
+	conf := &envTestConf{}
+	if err := loadEnv(k, "SDQENV_", conf, "toml"); err != nil {
+		return nil, nil, err
+	}
+	if err := k.UnmarshalWithConf("", conf, koanf.UnmarshalConf{Tag: "toml"}); err != nil {
+		t.Fatal(err)
+	}
+	return conf, k, nil
+}
+
+func TestEnvPathMatching(t *testing.T) {
+	t.Setenv("SDQENV_LOG_MAX_SIZE", "10")
+	t.Setenv("SDQENV_DATABASE_REDIS_1_ADDR", "10.0.0.2:6379")
+	t.Setenv("SDQENV_TRACING_HEADERS_AUTHORIZATION", "Bearer x")
+	t.Setenv("SDQENV_EXTRA_KEY", "v")
+	conf, k, err := loadTestEnv(t, map[string]any{
+		"database": map[string]any{
+			"redis": []any{map[string]any{"addr": "10.0.0.1:6379", "password": "p"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Log == nil || conf.Log.MaxSize != 10 {
+		t.Fatalf("键名中的-应写作_: %+v", conf.Log)
+	}
+	redis := conf.Database.Redis
+	if len(redis) != 2 || redis[1].Addr != "10.0.0.2:6379" {
+		t.Fatalf("切片下标未匹配: %+v", redis)
+	}
+	if redis[0].Addr != "10.0.0.1:6379" || redis[0].Password != "p" {
+		t.Fatalf("未设置的下标应保留原值: %+v", redis[0])
+	}
+	if conf.Tracing.Headers["authorization"] != "Bearer x" {
+		t.Fatalf("map的键未匹配: %v", conf.Tracing.Headers)
+	}
+	if k.String("extra.key") != "v" {
+		t.Fatalf("无法匹配字段时按_转.处理: %v", k.Raw())
+	}
+}
+
+func TestEnvCoercion(t *testing.T) {
+	t.Setenv("SDQENV_APP_TAGS", "a, b")
+	t.Setenv("SDQENV_APP_PORTS", "80,443")
+	t.Setenv("SDQENV_APP_TIMEOUT", "1m30s")
+	t.Setenv("SDQENV_APP_DEBUG", "true")
+	conf, _, err := loadTestEnv(t, map[string]any{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := conf.App
+	if strings.Join(app.Tags, "|") != "a|b" {
+		t.Fatalf("tags = %q", app.Tags)
+	}
+	if len(app.Ports) != 2 || app.Ports[0] != 80 || app.Ports[1] != 443 {
+		t.Fatalf("ports = %v", app.Ports)
+	}
+	if app.Timeout != 90*time.Second || !app.Debug {
+		t.Fatalf("timeout = %v, debug = %v", app.Timeout, app.Debug)
+	}
+}
+
+func TestEnvCoercionError(t *testing.T) {
+	for name, value := range map[string]string{
+		"SDQENV_APP_DEBUG":    "yes",
+		"SDQENV_APP_PORTS":    "80,https",
+		"SDQENV_LOG_MAX_SIZE": "10m",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			_, _, err := loadTestEnv(t, map[string]any{})
+			if err == nil || !strings.Contains(err.Error(), name) {
+				t.Fatalf("类型不符时应报告变量名: %v", err)
+			}
+		})
+	}
+}
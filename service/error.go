@@ -0,0 +1,69 @@
+package service
+
+import "errors"
+
+// 子系统名称，用于 InitError 与 WithOptional
+const (
+	SubsystemLog       = "log"
+	SubsystemGorm      = "gorm"
+	SubsystemRedis     = "redis"
+	SubsystemMongo     = "mongo"
+	SubsystemCron      = "cron"
+	SubsystemSonyFlake = "sony-flake"
+	SubsystemIpdb      = "ipdb"
+	SubsystemLimiter   = "limiter"
+	SubsystemCache     = "cache"
+	SubsystemGin       = "gin"
+	SubsystemConfig    = "config"
+	SubsystemTracing   = "tracing"
+)
+
+// InitError 子系统初始化错误
+type InitError struct {
+	Subsystem string // 初始化失败的子系统
+	Err       error  // 失败原因
+}
+
+func (e *InitError) Error() string {
+	return e.Subsystem + "初始化失败: " + e.Err.Error()
+}
+
+func (e *InitError) Unwrap() error {
+	return e.Err
+}
+
+// newInitError 包装子系统错误，err为nil时返回nil
+func newInitError(subsystem string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var initErr *InitError
+	if errors.As(err, &initErr) && initErr.Subsystem == subsystem {
+		return err
+	}
+	return &InitError{
+		Subsystem: subsystem,
+		Err:       err,
+	}
+}
+
+// CloseError 子系统关闭错误
+type CloseError struct {
+	Subsystem string // 关闭失败的子系统
+	Err       error  // 失败原因
+}
+
+func (e *CloseError) Error() string {
+	return e.Subsystem + "关闭失败: " + e.Err.Error()
+}
+
+func (e *CloseError) Unwrap() error {
+	return e.Err
+}
+
+func newCloseError(subsystem string, err error) error {
+	return &CloseError{
+		Subsystem: subsystem,
+		Err:       err,
+	}
+}
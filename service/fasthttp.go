@@ -2,9 +2,14 @@ package service
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"github.com/bytedance/sonic"
+	"github.com/google/uuid"
 	"github.com/sundaqiang/sdq-go/common"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"net"
 	"reflect"
@@ -26,7 +31,9 @@ type FastReqArg struct {
 	Cookie       string
 	MergedCookie bool
 	Headers      *[]FastHeader
-	Timeout      time.Duration
+	Timeout      time.Duration // 单次尝试的超时，默认30s
+	Retry        *Retry        // 重试策略，nil时不重试
+	Breaker      *Breaker      // 按域名熔断，nil时不熔断
 }
 
 type FastResArg struct {
@@ -75,220 +82,33 @@ func fastHTTPDialer(proxyAddr string) fasthttp.DialFunc {
 	}
 }
 
+// FastResponse 使用默认实例发起请求
 func FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
-	req := fasthttp.AcquireRequest()
-	defer fasthttp.ReleaseRequest(req) // 用完需要释放资源
-	resp := fasthttp.AcquireResponse()
-	defer fasthttp.ReleaseResponse(resp) // 用完需要释放资源
-
-	// 配置超时
-	if reqArg.Timeout > 0 {
-		req.SetTimeout(reqArg.Timeout)
-	} else {
-		req.SetTimeout(30 * time.Second)
-	}
-
-	// 配置访问方式
-	req.Header.SetMethod(reqArg.Method)
-
-	// 配置请求的url
-	fullUrl := reqArg.Url + reqArg.Path
-	if reqArg.Params != nil && reqArg.Params.Len() > 0 {
-		fullUrl += "?" + reqArg.Params.String()
-	}
-	req.SetRequestURI(fullUrl)
-
-	// 配置userAgent
-	userAgent := `Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36 Edg/108.0.1462.54`
-	if reqArg.UserAgent != "" {
-		userAgent = reqArg.UserAgent
-	}
-	req.Header.Set("User-Agent", userAgent)
-
-	// 配置cookie
-	if reqArg.Cookie != "" {
-		req.Header.Set("cookie", reqArg.Cookie)
-	}
-
-	// 配置其他协议头
-	if reqArg.Headers != nil {
-		for _, v := range *reqArg.Headers {
-			if v.Name == "Referer" && v.Value == "" {
-				v.Value = fullUrl
-			}
-			req.Header.Set(v.Name, v.Value)
-		}
-	}
-
-	// 配置body和contentType
-	var contentType string
-	if reqArg.Body != nil {
-		req.SetBody(reqArg.Body.QueryString())
-		contentType = `application/x-www-form-urlencoded; charset=UTF-8`
-	} else {
-		if reqArg.BodyJson != nil {
-			sonic.Pretouch(reflect.TypeOf(reqArg.BodyJson).Elem())
-			bodyByte, _ := json.Marshal(reqArg.BodyJson)
-			req.SetBody(bodyByte)
-			contentType = `application/json; charset=UTF-8`
-		}
-	}
-
-	// 配置contentType
-	if reqArg.ContentType != "" {
-		contentType = reqArg.ContentType
-	}
-	req.Header.SetContentType(contentType)
-
-	// 访问接口
-	if err := FastHttpClient.Do(req, resp); err != nil {
-		switch {
-		case reqArg.Body != nil:
-			ZapLog.Warn("FastResponse接口访问错误",
-				zap.String("url", fullUrl),
-				zap.String("method", reqArg.Method),
-				zap.String("content_type", contentType),
-				zap.String("user_agent", userAgent),
-				zap.ByteString("body", reqArg.Body.QueryString()),
-				zap.Error(err),
-			)
-		case reqArg.BodyJson != nil:
-			ZapLog.Warn("FastResponse接口访问错误",
-				zap.String("url", fullUrl),
-				zap.String("method", reqArg.Method),
-				zap.String("content_type", contentType),
-				zap.String("user_agent", userAgent),
-				zap.Reflect("body_json", reqArg.BodyJson),
-				zap.Error(err),
-			)
-		}
-		return false
-	}
-
-	// 获取返回的cookie
-	var newCookieArr []string
-	resp.Header.VisitAllCookie(func(_, value []byte) {
-		c := fasthttp.AcquireCookie()
-		err := c.ParseBytes(value)
-		if err != nil {
-			ZapLog.Warn("FastResponse获取cookie失败", zap.Error(err))
-			return
-		}
-		cName := common.Bytes2String(c.Key())
-		cValue := common.Bytes2String(c.Value())
-		newCookieArr = append(newCookieArr, cName+"="+cValue)
-	})
-
-	// 合并cookie
-	if reqArg.MergedCookie {
-		oldCookieArr := strings.Split(reqArg.Cookie, "; ")
-		for _, v := range oldCookieArr {
-			if !common.StringInSlice(newCookieArr, v) {
-				newCookieArr = append(newCookieArr, v)
-			}
-		}
-	}
+	return std.FastResponse(reqArg, resArg)
+}
 
-	// 配置返回的cookie
-	resArg.Cookie = strings.Join(newCookieArr, "; ")
+// FastResponse 发起请求，每次请求生成新的链路id
+func (a *App) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
+	ctx := context.WithValue(context.Background(), a.trace(), uuid.New().String())
+	return fastResponse(ctx, a, a.Http, a.traceLogger(ctx), reqArg, resArg)
+}
 
-	// 返回结果
-	resArg.StatusCode = resp.StatusCode()
-	resArg.Header = resp.Header.String()
-	if resArg.StatusCode != 200 {
-		switch {
-		case reqArg.Body != nil:
-			ZapLog.Warn("FastResponse接口访问失败",
-				zap.String("url", fullUrl),
-				zap.String("method", reqArg.Method),
-				zap.String("content_type", contentType),
-				zap.String("user_agent", userAgent),
-				zap.ByteString("body", reqArg.Body.QueryString()),
-			)
-		case reqArg.BodyJson != nil:
-			ZapLog.Warn("FastResponse接口访问失败",
-				zap.String("url", fullUrl),
-				zap.String("method", reqArg.Method),
-				zap.String("content_type", contentType),
-				zap.String("user_agent", userAgent),
-				zap.Reflect("body_json", reqArg.BodyJson),
-			)
-		}
-		return false
-	}
+// FastResponse 发起请求，日志和请求头带链路id，请求头带上traceparent
+func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
+	return fastResponse(t.Ctx, t.app, t.Http, t.Log, reqArg, resArg)
+}
 
-	// 解析返回数据
-	if resArg.BodyJson != nil {
-		isConvert := common.Json2Struct(resp.Body(), resArg.BodyJson)
-		if isConvert == nil {
-			switch {
-			case reqArg.Body != nil:
-				ZapLog.Info("FastResponse接口访问成功",
-					zap.String("url", fullUrl),
-					zap.String("method", reqArg.Method),
-					zap.String("content_type", contentType),
-					zap.String("user_agent", userAgent),
-					zap.ByteString("body", reqArg.Body.QueryString()),
-					zap.Reflect("res", resArg.BodyJson),
-				)
-			case reqArg.BodyJson != nil:
-				ZapLog.Info("FastResponse接口访问成功",
-					zap.String("url", fullUrl),
-					zap.String("method", reqArg.Method),
-					zap.String("content_type", contentType),
-					zap.String("user_agent", userAgent),
-					zap.Reflect("body_json", reqArg.BodyJson),
-					zap.Reflect("res", resArg.BodyJson),
-				)
-			}
-			return true
-		}
-	} else {
-		resArg.Body = resp.Body()
-		switch {
-		case reqArg.Body != nil:
-			ZapLog.Info("FastResponse接口访问成功",
-				zap.String("url", fullUrl),
-				zap.String("method", reqArg.Method),
-				zap.String("content_type", contentType),
-				zap.String("user_agent", userAgent),
-				zap.ByteString("body", reqArg.Body.QueryString()),
-				zap.ByteString("res", resArg.Body),
-			)
-		case reqArg.BodyJson != nil:
-			ZapLog.Info("FastResponse接口访问成功",
-				zap.String("url", fullUrl),
-				zap.String("method", reqArg.Method),
-				zap.String("content_type", contentType),
-				zap.String("user_agent", userAgent),
-				zap.Reflect("body_json", reqArg.BodyJson),
-				zap.ByteString("res", resArg.Body),
-			)
-		}
-		return true
-	}
-	switch {
-	case reqArg.Body != nil:
-		ZapLog.Warn("FastResponse接口访问异常",
-			zap.String("url", fullUrl),
-			zap.String("method", reqArg.Method),
-			zap.String("content_type", contentType),
-			zap.String("user_agent", userAgent),
-			zap.ByteString("body", reqArg.Body.QueryString()),
-		)
-	case reqArg.BodyJson != nil:
-		ZapLog.Warn("FastResponse接口访问异常",
-			zap.String("url", fullUrl),
-			zap.String("method", reqArg.Method),
-			zap.String("content_type", contentType),
-			zap.String("user_agent", userAgent),
-			zap.Reflect("body_json", reqArg.BodyJson),
-		)
-	}
-	return false
+// FastResponse 发起请求，日志和请求头带链路id
+func (t *GeneralTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
+	return fastResponse(*t.Ctx, t.app, t.Http, t.Log, reqArg, resArg)
 }
 
-func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
+/*
+fastResponse 使用client发起请求，log记录请求与结果，按域名和状态码记录耗时，在ctx的链路下创建span
+
+	请求头未指定时带上ctx中名为server.trace的链路id，下游的requestid会沿用它
+*/
+func fastResponse(ctx context.Context, a *App, client *fasthttp.Client, log *zap.Logger, reqArg *FastReqArg, resArg *FastResArg) bool {
 	req := fasthttp.AcquireRequest()
 	defer fasthttp.ReleaseRequest(req) // 用完需要释放资源
 	resp := fasthttp.AcquireResponse()
@@ -333,6 +153,11 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 		}
 	}
 
+	// 传递链路id，不覆盖调用方指定的值
+	if header, tid := common.KebabString(a.trace()), a.traceId(ctx); header != "" && tid != "" && len(req.Header.Peek(header)) == 0 {
+		req.Header.Set(header, tid)
+	}
+
 	// 配置body和contentType
 	var contentType string
 	if reqArg.Body != nil {
@@ -353,11 +178,34 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 	}
 	req.Header.SetContentType(contentType)
 
-	// 访问接口
-	if err := FastHttpClient.Do(req, resp); err != nil {
+	// 链路追踪
+	host := string(req.URI().Host())
+	_, span := a.tracer().Start(ctx, "HTTP "+reqArg.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", reqArg.Method),
+			attribute.String("url.full", reqArg.Url+reqArg.Path),
+			attribute.String("server.address", host),
+		),
+	)
+	propagator.Inject(trace.ContextWithSpan(ctx, span), fasthttpCarrier{h: &req.Header})
+
+	// 访问接口，按策略重试和熔断
+	attempts, err := a.doFast(ctx, client, req, resp, reqArg, host, log)
+	if attempts > 1 {
+		span.SetAttributes(attribute.Int("http.request.resend_count", attempts-1))
+	}
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode()))
+		if resp.StatusCode() >= fasthttp.StatusInternalServerError {
+			span.SetStatus(codes.Error, fasthttp.StatusMessage(resp.StatusCode()))
+		}
+	}
+	endSpan(span, err)
+	if err != nil {
 		switch {
 		case reqArg.Body != nil:
-			t.Log.Warn("FastResponse接口访问错误",
+			log.Warn("FastResponse接口访问错误",
 				zap.String("url", fullUrl),
 				zap.String("method", reqArg.Method),
 				zap.String("content_type", contentType),
@@ -366,7 +214,7 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 				zap.Error(err),
 			)
 		case reqArg.BodyJson != nil:
-			t.Log.Warn("FastResponse接口访问错误",
+			log.Warn("FastResponse接口访问错误",
 				zap.String("url", fullUrl),
 				zap.String("method", reqArg.Method),
 				zap.String("content_type", contentType),
@@ -384,7 +232,7 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 		c := fasthttp.AcquireCookie()
 		err := c.ParseBytes(value)
 		if err != nil {
-			t.Log.Error("FastResponse获取cookie失败", zap.Error(err))
+			log.Warn("FastResponse获取cookie失败", zap.Error(err))
 			return
 		}
 		cName := common.Bytes2String(c.Key())
@@ -411,7 +259,7 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 	if resArg.StatusCode != 200 {
 		switch {
 		case reqArg.Body != nil:
-			t.Log.Warn("FastResponse接口访问失败",
+			log.Warn("FastResponse接口访问失败",
 				zap.String("url", fullUrl),
 				zap.String("method", reqArg.Method),
 				zap.String("content_type", contentType),
@@ -419,7 +267,7 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 				zap.ByteString("body", reqArg.Body.QueryString()),
 			)
 		case reqArg.BodyJson != nil:
-			t.Log.Warn("FastResponse接口访问失败",
+			log.Warn("FastResponse接口访问失败",
 				zap.String("url", fullUrl),
 				zap.String("method", reqArg.Method),
 				zap.String("content_type", contentType),
@@ -436,7 +284,7 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 		if isConvert == nil {
 			switch {
 			case reqArg.Body != nil:
-				t.Log.Info("FastResponse接口访问成功",
+				log.Info("FastResponse接口访问成功",
 					zap.String("url", fullUrl),
 					zap.String("method", reqArg.Method),
 					zap.String("content_type", contentType),
@@ -445,7 +293,7 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 					zap.Reflect("res", resArg.BodyJson),
 				)
 			case reqArg.BodyJson != nil:
-				t.Log.Info("FastResponse接口访问成功",
+				log.Info("FastResponse接口访问成功",
 					zap.String("url", fullUrl),
 					zap.String("method", reqArg.Method),
 					zap.String("content_type", contentType),
@@ -460,7 +308,7 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 		resArg.Body = resp.Body()
 		switch {
 		case reqArg.Body != nil:
-			t.Log.Info("FastResponse接口访问成功",
+			log.Info("FastResponse接口访问成功",
 				zap.String("url", fullUrl),
 				zap.String("method", reqArg.Method),
 				zap.String("content_type", contentType),
@@ -469,7 +317,7 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 				zap.ByteString("res", resArg.Body),
 			)
 		case reqArg.BodyJson != nil:
-			t.Log.Info("FastResponse接口访问成功",
+			log.Info("FastResponse接口访问成功",
 				zap.String("url", fullUrl),
 				zap.String("method", reqArg.Method),
 				zap.String("content_type", contentType),
@@ -482,7 +330,7 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 	}
 	switch {
 	case reqArg.Body != nil:
-		t.Log.Warn("FastResponse接口访问异常",
+		log.Warn("FastResponse接口访问异常",
 			zap.String("url", fullUrl),
 			zap.String("method", reqArg.Method),
 			zap.String("content_type", contentType),
@@ -490,7 +338,7 @@ func (t *GinTracer) FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool {
 			zap.ByteString("body", reqArg.Body.QueryString()),
 		)
 	case reqArg.BodyJson != nil:
-		t.Log.Warn("FastResponse接口访问异常",
+		log.Warn("FastResponse接口访问异常",
 			zap.String("url", fullUrl),
 			zap.String("method", reqArg.Method),
 			zap.String("content_type", contentType),
@@ -2,63 +2,84 @@ package service
 
 import (
 	"errors"
-	"github.com/gin-contrib/requestid"
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-co-op/gocron/v2"
 	"github.com/go-playground/validator/v10"
+	"github.com/ipipdotnet/ipdb-go"
 	"github.com/orca-zhang/ecache"
 	"github.com/redis/go-redis/v9"
 	"github.com/sony/sonyflake"
+	"github.com/sundaqiang/sdq-go/common"
 	"github.com/valyala/fasthttp"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"reflect"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 type GinTracer struct {
-	Cache *ecache.Cache
-	Ctx   *gin.Context
-	Cron  gocron.Scheduler
-	Db    *gorm.DB
-	Http  *fasthttp.Client
-	Log   *zap.Logger
-	Rdb   []*redis.Client
-	Sony  *sonyflake.Sonyflake
-	Tid   string
+	Cache   *ecache.Cache
+	Ctx     *gin.Context
+	Cron    gocron.Scheduler
+	Db      *gorm.DB
+	Http    *fasthttp.Client
+	Ipdb    *ipdb.City
+	Limiter *RedisRate
+	Log     *zap.Logger
+	Mdb     *mongo.Client
+	Rdb     []*redis.Client
+	Sony    *sonyflake.Sonyflake
+	Tid     string
+	app     *App
 }
 
-// GetGinTracer 获取上下文实例
+// appContextKey 在gin.Context中保存所属实例的键
+const appContextKey = "sdq-go/app"
+
+// GetGinTracer 获取上下文实例，优先使用请求所属的实例
 func GetGinTracer(c *gin.Context) *GinTracer {
+	if v, ok := c.Get(appContextKey); ok {
+		if a, ok := v.(*App); ok {
+			return a.GetGinTracer(c)
+		}
+	}
+	return std.GetGinTracer(c)
+}
+
+// GetGinTracer 获取上下文实例
+func (a *App) GetGinTracer(c *gin.Context) *GinTracer {
 	var db *gorm.DB
-	db = Db
-	if Db != nil {
-		db = Db.WithContext(c)
+	db = a.Db
+	if a.Db != nil {
+		db = a.Db.WithContext(c)
 	}
 	return &GinTracer{
-		Cache: LRUCache,
-		Cron:  GoCron,
-		Ctx:   c,
-		Db:    db,
-		Http:  FastHttpClient,
-		Log:   ZapLog.With(zap.String(config.Server.Trace, requestid.Get(c))),
-		Rdb:   Rdb,
-		Sony:  SonyFlake,
-		Tid:   requestid.Get(c),
+		Cache:   a.Cache,
+		Cron:    a.Cron,
+		Ctx:     c,
+		Db:      db,
+		Http:    a.Http,
+		Ipdb:    a.Ipdb,
+		Limiter: a.Limiter,
+		Log:     a.traceLogger(c),
+		Mdb:     a.Mdb,
+		Rdb:     a.Rdb,
+		Sony:    a.Sony,
+		Tid:     a.traceId(c),
+		app:     a,
 	}
 }
 
 // BindJson 绑定数据
 func (t *GinTracer) BindJson(code int, body any) bool {
 	if err := t.Ctx.ShouldBindJSON(body); err != nil {
-		if config.Server.Trans {
-			t.GetHttpResErrorTrans(http.StatusOK, code, err)
-			return false
-		}
-		t.GetHttpResError(http.StatusOK, code, body, err)
+		t.bindFailed(code, body, err)
 		return false
 	}
 	return true
@@ -67,11 +88,7 @@ func (t *GinTracer) BindJson(code int, body any) bool {
 // BindForm 绑定数据
 func (t *GinTracer) BindForm(code int, body any) bool {
 	if err := t.Ctx.ShouldBindWith(body, binding.Form); err != nil {
-		if config.Server.Trans {
-			t.GetHttpResErrorTrans(http.StatusOK, code, err)
-			return false
-		}
-		t.GetHttpResError(http.StatusOK, code, body, err)
+		t.bindFailed(code, body, err)
 		return false
 	}
 	return true
@@ -80,56 +97,157 @@ func (t *GinTracer) BindForm(code int, body any) bool {
 // BindQuery 绑定数据
 func (t *GinTracer) BindQuery(code int, body any) bool {
 	if err := t.Ctx.ShouldBindQuery(body); err != nil {
-		if config.Server.Trans {
-			t.GetHttpResErrorTrans(http.StatusOK, code, err)
-			return false
+		t.bindFailed(code, body, err)
+		return false
+	}
+	return true
+}
+
+// BindUri 绑定路径参数
+func (t *GinTracer) BindUri(code int, body any) bool {
+	if err := t.Ctx.ShouldBindUri(body); err != nil {
+		t.bindFailed(code, body, err)
+		return false
+	}
+	return true
+}
+
+// BindHeader 绑定请求头
+func (t *GinTracer) BindHeader(code int, body any) bool {
+	if err := t.Ctx.ShouldBindHeader(body); err != nil {
+		t.bindFailed(code, body, err)
+		return false
+	}
+	return true
+}
+
+// FileLimit 上传文件的限制
+type FileLimit struct {
+	MaxSize int64    // 单个文件的最大字节数，0不限制
+	Types   []string // 允许的文件类型，按 common.GetFileType 识别，为空不限制
+}
+
+// FileError 上传文件不符合 FileLimit
+type FileError struct {
+	Field string
+	Name  string
+	Msg   string
+}
+
+func (e *FileError) Error() string {
+	return e.Field + "(" + e.Name + ")" + e.Msg
+}
+
+/*
+BindMultipart 绑定multipart表单，并检查所有上传文件的大小和类型
+
+	t.BindMultipart(1001, &req, service.FileLimit{MaxSize: 5 << 20, Types: []string{"jpg", "png"}})
+*/
+func (t *GinTracer) BindMultipart(code int, body any, limit FileLimit) bool {
+	if err := t.Ctx.ShouldBindWith(body, binding.FormMultipart); err != nil {
+		t.bindFailed(code, body, err)
+		return false
+	}
+	if err := checkFiles(t.Ctx.Request.MultipartForm, limit); err != nil {
+		t.bindFailed(code, body, err)
+		return false
+	}
+	return true
+}
+
+// checkFiles 检查上传文件
+func checkFiles(form *multipart.Form, limit FileLimit) error {
+	if form == nil {
+		return nil
+	}
+	for field, files := range form.File {
+		for _, fh := range files {
+			if limit.MaxSize > 0 && fh.Size > limit.MaxSize {
+				return &FileError{Field: field, Name: fh.Filename, Msg: "文件过大"}
+			}
+			if len(limit.Types) == 0 {
+				continue
+			}
+			fileType, err := readFileType(fh)
+			if err != nil {
+				return err
+			}
+			if fileType == "" || !common.StringInSlice(limit.Types, fileType) {
+				return &FileError{Field: field, Name: fh.Filename, Msg: "文件类型不支持"}
+			}
 		}
-		t.GetHttpResError(http.StatusOK, code, body, err)
+	}
+	return nil
+}
+
+// readFileType 读取文件头识别类型，不足10字节时无法可靠识别，按未知类型返回空
+func readFileType(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	head := make([]byte, 10)
+	n, err := io.ReadFull(f, head)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		// GetFileType按前缀双向匹配，过短的文件头会匹配到任意类型
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return common.GetFileType(head[:n]), nil
+}
+
+/*
+BindAll 按结构体的uri、header、form、json标签从多个来源绑定到同一个结构体，最后统一校验
+
+	type UpdateReq struct {
+		Id    int    `uri:"id" binding:"required"`
+		Token string `header:"X-Token" binding:"required"`
+		Name  string `json:"name" binding:"required"`
+	}
+*/
+func (t *GinTracer) BindAll(code int, body any) bool {
+	if err := bindSources(t.Ctx, body, detectSources(reflect.TypeOf(body))); err != nil {
+		t.bindFailed(code, body, err)
 		return false
 	}
 	return true
 }
 
+// bindFailed 绑定失败时按是否翻译返回错误
+func (t *GinTracer) bindFailed(code int, body any, err error) {
+	if t.app.transEnabled() {
+		t.GetHttpResErrorTrans(http.StatusOK, code, err)
+		return
+	}
+	t.GetHttpResError(http.StatusOK, code, body, err)
+}
+
 // GetHttpResSuccess 封装一个正确的返回值
 func (t *GinTracer) GetHttpResSuccess(status, code int, data any) {
-	t.Ctx.JSON(
-		status,
-		&gin.H{
-			"success":           true, // 布尔值，表示本次调用是否成功
-			"code":              code,
-			config.Server.Trace: t.Tid,
-			"data":              data, // 调用成功（success为true）时，服务端返回的数据。 不允许返回JS中undefine结果，0，false，"" 等
-		},
-	)
-	return
+	Respond(t, status, Response[any]{
+		Success: true, // 布尔值，表示本次调用是否成功
+		Code:    code,
+		Data:    data, // 调用成功（success为true）时，服务端返回的数据。 不允许返回JS中undefine结果，0，false，"" 等
+	})
 }
 
 // GetHttpResFailure 封装一个失败的返回值
 func (t *GinTracer) GetHttpResFailure(status, code int, msg any) {
-	t.Ctx.AbortWithStatusJSON(
-		status,
-		&gin.H{
-			"success":           false, // 布尔值，表示本次调用是否成功
-			"code":              code,  // 字符串，调用失败（success为false）时，服务端返回的错误码
-			config.Server.Trace: t.Tid,
-			"msg":               msg, // 字符串，调用失败（success为false）时，服务端返回的错误信息
-		},
-	)
-	return
+	Respond(t, status, Response[any]{
+		Code: code, // 字符串，调用失败（success为false）时，服务端返回的错误码
+		Msg:  msg,  // 字符串，调用失败（success为false）时，服务端返回的错误信息
+	})
 }
 
 // GetHttpResError 封装一个错误的返回值
 func (t *GinTracer) GetHttpResError(status, code int, data any, err error) {
-	t.Ctx.AbortWithStatusJSON(
-		status,
-		&gin.H{
-			"success":           false, // 布尔值，表示本次调用是否成功
-			"code":              code,  // 字符串，调用失败（success为false）时，服务端返回的错误码
-			config.Server.Trace: t.Tid,
-			"msg":               getValidMsg(err, data), // 字符串，调用失败（success为false）时，服务端返回的错误信息
-		},
-	)
-	return
+	Respond(t, status, Response[any]{
+		Code: code,
+		Msg:  getValidMsg(err, data),
+	})
 }
 
 // GetHttpResErrorTrans 封装一个错误的返回值,翻译
@@ -138,17 +256,17 @@ func (t *GinTracer) GetHttpResErrorTrans(status, code int, err error) {
 		t.GetHttpResFailure(http.StatusOK, code, "缺少参数")
 		return
 	}
+	var fileErr *FileError
+	if errors.As(err, &fileErr) {
+		t.GetHttpResFailure(http.StatusOK, code, fileErr.Error())
+		return
+	}
 	var errs validator.ValidationErrors
 	if errors.As(err, &errs) {
-		t.Ctx.AbortWithStatusJSON(
-			status,
-			&gin.H{
-				"success":           false, // 布尔值，表示本次调用是否成功
-				"code":              code,  // 字符串，调用失败（success为false）时，服务端返回的错误码
-				config.Server.Trace: t.Tid,
-				"msg":               removeTopStruct(errs.Translate(trans)), // 字符串，调用失败（success为false）时，服务端返回的错误信息
-			},
-		)
+		Respond(t, status, Response[any]{
+			Code: code,
+			Msg:  removeTopStruct(errs.Translate(trans)),
+		})
 		return
 	}
 	if strings.Contains(err.Error(), "cannot unmarshal") {
@@ -2,8 +2,7 @@ package service
 
 import (
 	"context"
-	"github.com/gin-gonic/gin"
-	"github.com/sundaqiang/sdq-go/common"
+	"errors"
 	"go.uber.org/zap/zapcore"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/sqlite"
@@ -18,10 +17,10 @@ import (
 )
 
 type Gorm struct {
-	Type     string     `toml:"type"`
+	Type     string     `toml:"type" validate:"omitempty,oneof=mysql sqlite"`
 	Host     string     `toml:"host"`
 	User     string     `toml:"user"`
-	Password string     `toml:"password"`
+	Password string     `toml:"password" secret:"true"`
 	Name     string     `toml:"name"`
 	Resolver []Resolver `toml:"resolver"`
 }
@@ -30,44 +29,24 @@ type Resolver struct {
 	Type     string `toml:"type"`
 	Host     string `toml:"host"`
 	User     string `toml:"user"`
-	Password string `toml:"password"`
+	Password string `toml:"password" secret:"true"`
 	Name     string `toml:"name"`
 	Driver   *gorm.Dialector
 	Data     []interface{}
 }
 
-func initDB(info *Gorm) {
+func (a *App) initDB(info *Gorm) error {
 	// 将gorm的日志改为zap
-	newLogger := zapgorm2.New(ZapLog)
+	newLogger := zapgorm2.New(a.Log)
 	newLogger.LogLevel = logger.Info
 	newLogger.SlowThreshold = time.Second
 	newLogger.SkipCallerLookup = false
 	newLogger.IgnoreRecordNotFoundError = true
-	if config.Server.Trace != "" {
+	trace := a.trace()
+	if trace != "" {
 		newLogger.Context = func(ctx context.Context) []zapcore.Field {
-			c, ok := ctx.(*gin.Context)
-			if ok {
-				return []zapcore.Field{
-					zap.String(
-						config.Server.Trace,
-						c.Writer.Header().Get(
-							common.KebabString(config.Server.Trace),
-						),
-					),
-				}
-			}
-			// 获取键值
-			value := ctx.Value(config.Server.Trace)
-			// 检查值是否存在
-			if value != nil {
-				return []zapcore.Field{
-					zap.String(
-						config.Server.Trace,
-						c.Writer.Header().Get(
-							common.KebabString(config.Server.Trace),
-						),
-					),
-				}
+			if id := a.traceId(ctx); id != "" {
+				return []zapcore.Field{zap.String(trace, id)}
 			}
 			return []zapcore.Field{}
 		}
@@ -79,8 +58,10 @@ func initDB(info *Gorm) {
 		driver = mysql.Open(info.User + ":" + info.Password + "@tcp(" + info.Host + ")/" + info.Name + "?charset=utf8mb4&parseTime=True&loc=Asia%2FShanghai")
 	case "sqlite":
 		driver = sqlite.Open(info.Name)
+	default:
+		return errors.New("不支持的数据库类型: " + info.Type)
 	}
-	Db, err = gorm.Open(driver, &gorm.Config{
+	a.Db, err = gorm.Open(driver, &gorm.Config{
 		Logger:                 newLogger,
 		QueryFields:            true,
 		SkipDefaultTransaction: true,
@@ -91,7 +72,14 @@ func initDB(info *Gorm) {
 	})
 
 	if err != nil {
-		ZapLog.Fatal("数据库连接失败", zap.Error(err))
+		return err
+	}
+
+	if err = a.Db.Use(gormMetrics{m: a.Metrics()}); err != nil {
+		return err
+	}
+	if err = a.Db.Use(gormTracing{app: a}); err != nil {
+		return err
 	}
 
 	if len(info.Resolver) > 0 {
@@ -102,22 +90,26 @@ func initDB(info *Gorm) {
 			case "sqlite":
 				driver = sqlite.Open(info.Name)
 			}
-			err = Db.Use(dbresolver.Register(dbresolver.Config{
+			err = a.Db.Use(dbresolver.Register(dbresolver.Config{
 				Sources: []gorm.Dialector{driver},
 			}, resolver.Data...))
 			if err != nil {
-				ZapLog.Fatal("数据库连接失败", zap.Error(err))
+				return err
 			}
 		}
 	}
 
-	sqlDB, err := Db.DB()
+	sqlDB, err := a.Db.DB()
+	if err != nil {
+		return err
+	}
 	sqlDB.SetMaxOpenConns(500)
 	sqlDB.SetMaxIdleConns(50)
 	sqlDB.SetConnMaxLifetime(15 * time.Minute)
-	if err != nil {
-		ZapLog.Fatal("数据库连接状态", zap.Error(err))
-	} else {
-		ZapLog.Info("数据库连接成功")
-	}
+	a.onClose(SubsystemGorm, func(ctx context.Context) error {
+		return sqlDB.Close()
+	})
+	a.onHealth(SubsystemGorm, SubsystemGorm, sqlDB.PingContext)
+	a.Log.Info("数据库连接成功")
+	return nil
 }
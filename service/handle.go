@@ -0,0 +1,177 @@
+package service
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// bindSource 参数来源
+type bindSource uint8
+
+const (
+	sourceJson bindSource = 1 << iota
+	sourceForm
+	sourceUri
+	sourceHeader
+)
+
+// maxMultipartMemory 与gin一致，超出部分写入临时文件
+const maxMultipartMemory = 32 << 20
+
+// detectSources 按结构体标签判断需要绑定的来源，包含匿名嵌入的字段
+func detectSources(t reflect.Type) bindSource {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return sourceJson
+	}
+	var sources bindSource
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Tag == "" {
+			sources |= detectSources(field.Type)
+			continue
+		}
+		if _, ok := field.Tag.Lookup("json"); ok {
+			sources |= sourceJson
+		}
+		if _, ok := field.Tag.Lookup("form"); ok {
+			sources |= sourceForm
+		}
+		if _, ok := field.Tag.Lookup("uri"); ok {
+			sources |= sourceUri
+		}
+		if _, ok := field.Tag.Lookup("header"); ok {
+			sources |= sourceHeader
+		}
+	}
+	if sources == 0 {
+		// 没有标签时按字段名解析json
+		return sourceJson
+	}
+	return sources
+}
+
+/*
+bindSources 依次从query和表单、json请求体、请求头、路径参数绑定，全部完成后统一校验
+
+	后绑定的来源覆盖先绑定的同名字段，路径参数最后绑定，请求体无法覆盖路由和鉴权依赖的路径参数
+*/
+func bindSources(c *gin.Context, obj any, sources bindSource) error {
+	if sources&sourceForm != 0 {
+		if err := c.Request.ParseMultipartForm(maxMultipartMemory); err != nil &&
+			!errors.Is(err, http.ErrNotMultipart) {
+			return err
+		}
+		if err := binding.MapFormWithTag(obj, c.Request.Form, "form"); err != nil {
+			return err
+		}
+	}
+	if sources&sourceJson != 0 && c.Request.Body != nil &&
+		c.ContentType() == binding.MIMEJSON {
+		if err := json.NewDecoder(c.Request.Body).Decode(obj); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+	if sources&sourceHeader != 0 {
+		// 同时支持规范写法和小写写法的标签
+		headers := make(map[string][]string, len(c.Request.Header)*2)
+		for k, v := range c.Request.Header {
+			headers[k] = v
+			headers[strings.ToLower(k)] = v
+		}
+		if err := binding.MapFormWithTag(obj, headers, "header"); err != nil {
+			return err
+		}
+	}
+	if sources&sourceUri != 0 {
+		params := make(map[string][]string, len(c.Params))
+		for _, v := range c.Params {
+			params[v.Key] = []string{v.Value}
+		}
+		if err := binding.MapFormWithTag(obj, params, "uri"); err != nil {
+			return err
+		}
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// Paged 带分页信息的返回值，Handle 会把Page放到返回结构的分页字段
+type Paged[T any] struct {
+	List T
+	Page Page
+}
+
+func (p Paged[T]) paged() (any, Page) {
+	return p.List, p.Page
+}
+
+// failBind Handle 的参数绑定失败，不向调用方暴露解析错误的原文
+func (t *GinTracer) failBind(body any, err error) {
+	var msg any
+	var errs validator.ValidationErrors
+	if errors.As(err, &errs) {
+		if t.app.transEnabled() {
+			msg = removeTopStruct(errs.Translate(trans))
+		} else if m := getValidMsg(err, body); m != "" {
+			msg = m
+		}
+	}
+	if msg == nil {
+		msg = ErrBadRequest.Message(t.locale())
+	}
+	t.Log.Debug("参数绑定失败", zap.Error(err))
+	Respond(t, ErrBadRequest.Status, Response[any]{
+		Code: ErrBadRequest.Code,
+		Msg:  msg,
+	})
+}
+
+type pagedResult interface {
+	paged() (any, Page)
+}
+
+/*
+Handle 把类型化的处理函数适配为gin.HandlerFunc
+
+	按Req的uri、header、form、json标签绑定参数并校验，失败时按 ErrBadRequest 的状态码和code返回，
+	msg为校验翻译(开启trans时)或字段的msg标签，都没有时使用 ErrBadRequest 的提示信息
+	返回error时按 Fail 输出错误码，否则按 Success 输出，已自行写入响应时不再输出
+
+	r.GET("user/:id", service.Handle(func(t *service.GinTracer, req *GetUserReq) (*User, error) {
+		return findUser(t, req.Id)
+	}))
+*/
+func Handle[Req, Resp any](fn func(t *GinTracer, req *Req) (Resp, error)) gin.HandlerFunc {
+	sources := detectSources(reflect.TypeOf((*Req)(nil)).Elem())
+	return func(c *gin.Context) {
+		t := GetGinTracer(c)
+		req := new(Req)
+		if err := bindSources(c, req, sources); err != nil {
+			t.failBind(req, err)
+			return
+		}
+		resp, err := fn(t, req)
+		if c.Writer.Written() || c.IsAborted() {
+			return
+		}
+		if err != nil {
+			t.Fail(err)
+			return
+		}
+		if p, ok := any(resp).(pagedResult); ok {
+			list, page := p.paged()
+			t.SuccessPage(list, page)
+			return
+		}
+		t.Success(resp)
+	}
+}
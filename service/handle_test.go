@@ -0,0 +1,83 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type handleReq struct {
+	Name string `json:"name" binding:"required"`
+	Age  int    `json:"age" binding:"min=1" msg:"年龄错误"`
+}
+
+func handleRouter(r *gin.Engine) {
+	r.POST("user", Handle(func(t *GinTracer, req *handleReq) (string, error) {
+		return req.Name, nil
+	}))
+}
+
+func postJson(body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/user", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// decodeResponse 按默认返回结构解析
+func decodeResponse(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var res map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("%v: %s", err, w.Body.String())
+	}
+	return res
+}
+
+func TestHandleBindFailureUsesBadRequest(t *testing.T) {
+	r := newTestEngine(t, &Config{}, handleRouter)
+
+	cases := []struct {
+		name, body, msg string
+	}{
+		{"没有msg标签", `{"age":1}`, "参数异常"},
+		{"msg标签", `{"name":"a","age":0}`, "年龄错误"},
+		{"解析失败不暴露原文", `{"name":1}`, "参数异常"},
+	}
+	for _, c := range cases {
+		w := serve(r, postJson(c.body))
+		if w.Code != ErrBadRequest.Status {
+			t.Fatalf("%s: status = %d", c.name, w.Code)
+		}
+		res := decodeResponse(t, w)
+		if fmt.Sprint(res["code"]) != fmt.Sprint(ErrBadRequest.Code) || res["msg"] != c.msg {
+			t.Fatalf("%s: %v", c.name, res)
+		}
+	}
+
+	w := serve(r, postJson(`{"name":"a","age":1}`))
+	if res := decodeResponse(t, w); res["success"] != true || res["data"] != "a" {
+		t.Fatalf("绑定成功时返回%s", w.Body.String())
+	}
+}
+
+func TestHandleBindFailureFollowsRegisterError(t *testing.T) {
+	status, msgs := ErrBadRequest.Status, ErrBadRequest.Msgs
+	defer func() {
+		ErrBadRequest.Status, ErrBadRequest.Msgs = status, msgs
+		builtinCodes[ErrBadRequest.Code] = true
+	}()
+	RegisterError(http.StatusBadRequest, http.StatusUnprocessableEntity, map[string]string{"zh": "参数错误"})
+
+	r := newTestEngine(t, &Config{}, handleRouter)
+	w := serve(r, postJson(`{}`))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if res := decodeResponse(t, w); res["msg"] != "参数错误" {
+		t.Fatalf("msg = %v", res["msg"])
+	}
+}
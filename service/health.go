@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	HealthUp       = "up"
+	HealthDown     = "down"
+	HealthDegraded = "degraded" // 只有可选子系统异常
+)
+
+// HealthChecker 组件健康检查函数，返回nil表示正常
+type HealthChecker func(ctx context.Context) error
+
+// healthCheck 已登记的检查项
+type healthCheck struct {
+	subsystem string
+	name      string
+	fn        HealthChecker
+
+	mu          sync.Mutex
+	lastErr     string
+	lastErrTime time.Time
+}
+
+// HealthStatus 单个组件的检查结果
+type HealthStatus struct {
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Optional    bool   `json:"optional,omitempty"`
+	Latency     string `json:"latency"`
+	Error       string `json:"error,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+	LastErrorAt string `json:"last_error_at,omitempty"`
+}
+
+// HealthReport 就绪检查的汇总结果
+type HealthReport struct {
+	Status     string         `json:"status"`
+	Components []HealthStatus `json:"components"`
+}
+
+// onHealth 登记子系统的检查函数，同名的检查项会被替换
+func (a *App) onHealth(subsystem, name string, fn HealthChecker) {
+	a.healthMu.Lock()
+	defer a.healthMu.Unlock()
+	for i, v := range a.health {
+		if v.name == name {
+			a.health[i] = &healthCheck{subsystem: subsystem, name: name, fn: fn}
+			return
+		}
+	}
+	a.health = append(a.health, &healthCheck{subsystem: subsystem, name: name, fn: fn})
+}
+
+// RegisterHealth 在默认实例上登记自定义检查项
+func RegisterHealth(name string, fn HealthChecker) {
+	std.RegisterHealth(name, fn)
+}
+
+// RegisterHealth 登记自定义检查项，会出现在就绪检查中
+func (a *App) RegisterHealth(name string, fn HealthChecker) {
+	a.onHealth(name, name, fn)
+}
+
+// CheckHealth 检查默认实例
+func CheckHealth(ctx context.Context) *HealthReport {
+	return std.CheckHealth(ctx)
+}
+
+/*
+CheckHealth 并发执行所有检查项
+
+	任一必选组件异常为down，只有可选组件异常为degraded
+	每项的超时时间为server.health-timeout秒，默认3秒
+*/
+func (a *App) CheckHealth(ctx context.Context) *HealthReport {
+	a.healthMu.Lock()
+	checks := make([]*healthCheck, len(a.health))
+	copy(checks, a.health)
+	a.healthMu.Unlock()
+
+	timeout := 3 * time.Second
+	if server := a.Config().Server; server != nil && server.HealthTimeout > 0 {
+		timeout = time.Duration(server.HealthTimeout) * time.Second
+	}
+	report := &HealthReport{
+		Status:     HealthUp,
+		Components: make([]HealthStatus, len(checks)),
+	}
+	var wg sync.WaitGroup
+	for i, v := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report.Components[i] = v.run(ctx, timeout)
+			report.Components[i].Optional = a.optional[v.subsystem]
+		}()
+	}
+	wg.Wait()
+	sort.SliceStable(report.Components, func(i, j int) bool {
+		return report.Components[i].Name < report.Components[j].Name
+	})
+	for _, v := range report.Components {
+		if v.Status == HealthUp {
+			continue
+		}
+		if !v.Optional {
+			report.Status = HealthDown
+			break
+		}
+		report.Status = HealthDegraded
+	}
+	if a.draining.Load() {
+		// 关闭过程中不再接收流量
+		report.Status = HealthDown
+	}
+	return report
+}
+
+// run 执行一次检查并记录最近一次错误
+func (h *healthCheck) run(ctx context.Context, timeout time.Duration) HealthStatus {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	err := h.fn(ctx)
+	status := HealthStatus{
+		Name:    h.name,
+		Status:  HealthUp,
+		Latency: time.Since(start).String(),
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err != nil {
+		status.Status = HealthDown
+		status.Error = err.Error()
+		h.lastErr = err.Error()
+		h.lastErrTime = time.Now()
+	}
+	if h.lastErr != "" {
+		status.LastError = h.lastErr
+		status.LastErrorAt = h.lastErrTime.Format(time.RFC3339)
+	}
+	return status
+}
+
+// registerHealth 按server.healthz、server.readyz注册存活检查和就绪检查
+func (a *App) registerHealth(r *gin.Engine, server *Server) {
+	if server.Healthz != "" && server.Healthz != "-" {
+		r.GET(server.Healthz, func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"status": HealthUp})
+		})
+	}
+	if server.Readyz != "" && server.Readyz != "-" {
+		r.GET(server.Readyz, func(c *gin.Context) {
+			report := a.CheckHealth(c.Request.Context())
+			code := http.StatusOK
+			if report.Status == HealthDown {
+				code = http.StatusServiceUnavailable
+			}
+			if s := a.Config().Server; s == nil || !s.HealthDetail {
+				report.hideDetail()
+			}
+			c.JSON(code, report)
+		})
+	}
+}
+
+// hideDetail 去掉错误信息，只保留每项是否正常，避免对外暴露地址等内部信息
+func (r *HealthReport) hideDetail() {
+	for i := range r.Components {
+		r.Components[i].Error = ""
+		r.Components[i].LastError = ""
+		r.Components[i].LastErrorAt = ""
+	}
+}
+
+// errNotInitialized 组件未初始化或已关闭
+var errNotInitialized = errors.New("未初始化")
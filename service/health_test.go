@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHealthDefaultPathsHideDetail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a, err := NewApp(testConfig(t, &Config{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.RegisterHealth("db", func(ctx context.Context) error {
+		return errors.New("dial tcp 10.0.0.1:3306: connection refused")
+	})
+	r, err := a.newGin(pingRouter, nil, newGinOptions(a.Config().Server, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(r, httptest.NewRequest(http.MethodGet, "/healthz", nil)); w.Code != http.StatusOK {
+		t.Fatalf("healthz = %d", w.Code)
+	}
+	w := serve(r, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readyz = %d", w.Code)
+	}
+	if body := w.Body.String(); strings.Contains(body, "10.0.0.1") || !strings.Contains(body, HealthDown) {
+		t.Fatalf("默认不应返回错误详情: %s", body)
+	}
+
+	conf := *a.Config()
+	server := *conf.Server
+	server.HealthDetail = true
+	conf.Server = &server
+	a.config.Store(&conf)
+	w = serve(r, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if !strings.Contains(w.Body.String(), "10.0.0.1") {
+		t.Fatalf("开启health-detail后应返回错误详情: %s", w.Body.String())
+	}
+}
+
+func TestHealthCustomAndDisabledPaths(t *testing.T) {
+	// 用户路由占用了/healthz，改为其他路径且不注册/readyz
+	r := newTestEngine(t, &Config{Server: &Server{Healthz: "/-/live", Readyz: "-"}}, func(r *gin.Engine) {
+		r.GET("healthz", func(c *gin.Context) { c.String(http.StatusOK, "user") })
+	})
+	if w := serve(r, httptest.NewRequest(http.MethodGet, "/healthz", nil)); w.Body.String() != "user" {
+		t.Fatalf("healthz = %q", w.Body.String())
+	}
+	if w := serve(r, httptest.NewRequest(http.MethodGet, "/-/live", nil)); w.Code != http.StatusOK {
+		t.Fatalf("自定义路径 = %d", w.Code)
+	}
+	if w := serve(r, httptest.NewRequest(http.MethodGet, "/readyz", nil)); w.Code != http.StatusNotFound {
+		t.Fatalf("readyz应不注册, 返回%d", w.Code)
+	}
+
+	err := ValidateConfig(&Config{Server: &Server{Readyz: "readyz"}})
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Fields[0].Key != "server.readyz" {
+		t.Fatalf("路径需以/开头: %v", err)
+	}
+}
@@ -1,41 +1,64 @@
 package service
 
 import (
+	"context"
 	"github.com/go-co-op/gocron/v2"
 	"github.com/ipipdotnet/ipdb-go"
 	"github.com/sundaqiang/sdq-go/common"
 	"github.com/valyala/fasthttp"
 	"go.uber.org/zap"
+	"os"
 	"time"
 )
 
-func InitIpdb(path string, cron int64) bool {
+// InitIpdb 加载ipdb，cron>0时每cron小时更新一次
+func InitIpdb(path string, cron int64) error {
+	return withStd(func(a *App) error {
+		return a.InitIpdb(path, cron)
+	})
+}
+
+// InitIpdb 加载ipdb，cron>0时每cron小时更新一次
+func (a *App) InitIpdb(path string, cron int64) error {
 	var err error
-	Ipdb, err = ipdb.NewCity(path)
+	a.Ipdb, err = ipdb.NewCity(path)
 	if err != nil {
-		return false
+		return newInitError(SubsystemIpdb, err)
 	}
-	if cron > 0 && GoCron != nil {
-		_, err = GoCron.NewJob(
+	a.onHealth(SubsystemIpdb, SubsystemIpdb, func(ctx context.Context) error {
+		if a.Ipdb == nil {
+			return errNotInitialized
+		}
+		_, err := os.Stat(path)
+		return err
+	})
+	if cron > 0 && a.Cron != nil {
+		_, err = a.Cron.NewJob(
 			gocron.DurationJob(
 				time.Duration(cron)*time.Hour,
 			),
 			gocron.NewTask(
-				UpdateIpdb,
+				a.UpdateIpdb,
 				path,
 			),
 			gocron.WithStartAt(gocron.WithStartDateTime(time.Unix(time.Now().Unix()+8, 0))),
 			gocron.WithTags("定时更新ipdb"),
 		)
 		if err != nil {
-			return false
+			return newInitError(SubsystemIpdb, err)
 		}
 	}
 
-	return true
+	return nil
 }
 
+// UpdateIpdb 下载最新的ipdb并重新加载
 func UpdateIpdb(path string) {
+	std.UpdateIpdb(path)
+}
+
+// UpdateIpdb 下载最新的ipdb并重新加载
+func (a *App) UpdateIpdb(path string) {
 	if path == "" {
 		return
 	}
@@ -53,8 +76,8 @@ func UpdateIpdb(path string) {
 		// 配置请求的url
 		req.SetRequestURI(v)
 		// 访问接口
-		if err := FastHttpClient.Do(req, resp); err != nil {
-			ZapLog.Error("获取远程ipdb失败", zap.Error(err))
+		if err := a.Http.Do(req, resp); err != nil {
+			a.Log.Error("获取远程ipdb失败", zap.Error(err))
 			continue
 		}
 		break
@@ -62,12 +85,12 @@ func UpdateIpdb(path string) {
 	res := resp.Body()
 	err := common.CreateFile(path, &res)
 	if err != nil {
-		ZapLog.Error("保存远程ipdb失败", zap.Error(err))
+		a.Log.Error("保存远程ipdb失败", zap.Error(err))
 		return
 	}
-	err = Ipdb.Reload(path)
+	err = a.Ipdb.Reload(path)
 	if err != nil {
-		ZapLog.Error("ipdb更新失败", zap.Error(err))
+		a.Log.Error("ipdb更新失败", zap.Error(err))
 		return
 	}
 }
@@ -5,8 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/redis/go-redis/v9"
-	"go.uber.org/zap"
 	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -152,7 +152,9 @@ type Limit struct {
 
 // RedisRate controls how frequently events are allowed to happen.
 type RedisRate struct {
-	rdb *redis.Client
+	// rdb 热更新时原地切换
+	rdb atomic.Pointer[redis.Client]
+	app *App
 }
 
 type Result struct {
@@ -181,24 +183,55 @@ type Result struct {
 	ResetAfter time.Duration
 }
 
-func InitLimit(index int) {
-	if index < 0 || index >= len(Rdb) {
-		ZapLog.Fatal("limit初始化失败", zap.Error(errors.New("索引超出Rdb")))
-		return
+// InitLimit 使用第index个redis初始化限流器
+func InitLimit(index int) error {
+	return withStd(func(a *App) error {
+		return a.InitLimit(index)
+	})
+}
+
+// InitLimit 使用第index个redis初始化限流器
+func (a *App) InitLimit(index int) error {
+	if index < 0 || index >= len(a.Rdb) {
+		return newInitError(SubsystemLimiter, errors.New("索引超出Rdb"))
+	}
+
+	rdb := a.Rdb[index]
+	if err := loadLimitScripts(rdb); err != nil {
+		return newInitError(SubsystemLimiter, err)
+	}
+	a.Limiter = &RedisRate{app: a}
+	a.Limiter.rdb.Store(rdb)
+	a.Log.Info("limit初始化成功")
+	return nil
+}
+
+// switchLimit 热更新时切换限流器使用的redis，限流器实例不变
+func (a *App) switchLimit(index int) error {
+	if a.Limiter == nil {
+		return errors.New("限流器未初始化，重启后生效")
+	}
+	if index < 0 || index >= len(a.Rdb) {
+		return errors.New("索引超出Rdb")
+	}
+	rdb := a.Rdb[index]
+	if err := loadLimitScripts(rdb); err != nil {
+		return err
 	}
+	a.Limiter.rdb.Store(rdb)
+	return nil
+}
 
+// loadLimitScripts 检查redis连接并加载限流脚本
+func loadLimitScripts(rdb *redis.Client) error {
 	ctx := context.Background()
-	Limiter = &RedisRate{
-		rdb: Rdb[index],
+	if err := rdb.Del(ctx, redisPrefix).Err(); err != nil {
+		return err
 	}
-	err := Limiter.Reset(ctx, "")
-	if err != nil {
-		ZapLog.Fatal("limit初始化错误", zap.Error(errors.New("ping失败")))
-		return
+	if err := allowN.Load(ctx, rdb).Err(); err != nil {
+		return err
 	}
-	allowN.Load(ctx, Limiter.rdb)
-	allowAtMost.Load(ctx, Limiter.rdb)
-	ZapLog.Info("limit初始化成功")
+	return allowAtMost.Load(ctx, rdb).Err()
 }
 
 func dur(f float64) time.Duration {
@@ -259,8 +292,14 @@ func (l *RedisRate) Allow(ctx context.Context, key string, limit Limit) (*Result
 
 // AllowN reports whether n events may happen at time now.
 func (l *RedisRate) AllowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	res, err := l.allowN(ctx, key, limit, n)
+	l.observe(res, err)
+	return res, err
+}
+
+func (l *RedisRate) allowN(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
 	values := []interface{}{limit.Burst, limit.Rate, limit.Period.Seconds(), n}
-	v, err := allowN.Run(ctx, l.rdb, []string{redisPrefix + key}, values...).Result()
+	v, err := allowN.Run(ctx, l.rdb.Load(), []string{redisPrefix + key}, values...).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -289,8 +328,14 @@ func (l *RedisRate) AllowN(ctx context.Context, key string, limit Limit, n int)
 
 // AllowAtMost reports whether at most n events may happen at time now. It returns number of allowed events that is less than or equal to n.
 func (l *RedisRate) AllowAtMost(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
+	res, err := l.allowAtMost(ctx, key, limit, n)
+	l.observe(res, err)
+	return res, err
+}
+
+func (l *RedisRate) allowAtMost(ctx context.Context, key string, limit Limit, n int) (*Result, error) {
 	values := []interface{}{limit.Burst, limit.Rate, limit.Period.Seconds(), n}
-	v, err := allowAtMost.Run(ctx, l.rdb, []string{redisPrefix + key}, values...).Result()
+	v, err := allowAtMost.Run(ctx, l.rdb.Load(), []string{redisPrefix + key}, values...).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -317,7 +362,14 @@ func (l *RedisRate) AllowAtMost(ctx context.Context, key string, limit Limit, n
 	return res, nil
 }
 
+// observe 记录到所属实例的指标，直接构造的限流器不记录
+func (l *RedisRate) observe(res *Result, err error) {
+	if l.app != nil {
+		l.app.Metrics().observeLimit(res, err)
+	}
+}
+
 // Reset gets a key and reset all limitations and previous usages
 func (l *RedisRate) Reset(ctx context.Context, key string) error {
-	return l.rdb.Del(ctx, redisPrefix+key).Err()
+	return l.rdb.Load().Del(ctx, redisPrefix+key).Err()
 }
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/go-co-op/gocron/v2"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Metrics 实例的prometheus指标，配置server.metrics后通过该路径输出
+
+	自定义指标注册到Registry即可一起输出
+	service.GetMetrics().Registry.MustRegister(myCounter)
+*/
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	httpRequests  *prometheus.CounterVec
+	httpLatency   *prometheus.HistogramVec
+	redisLatency  *prometheus.HistogramVec
+	redisErrors   *prometheus.CounterVec
+	dbLatency     *prometheus.HistogramVec
+	dbErrors      *prometheus.CounterVec
+	clientLatency *prometheus.HistogramVec
+	clientRetries *prometheus.CounterVec
+	breakerState  *prometheus.GaugeVec
+	breakerReject *prometheus.CounterVec
+	limiter       *prometheus.CounterVec
+	cronRuns      *prometheus.CounterVec
+	cronLatency   *prometheus.HistogramVec
+}
+
+// fastBuckets 缓存和数据库的耗时分布，比默认分桶更细
+var fastBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5}
+
+func newMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "HTTP请求数",
+		}, []string{"method", "route", "status"}),
+		httpLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP请求耗时",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		redisLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "redis_command_duration_seconds",
+			Help:    "redis命令耗时，管道按pipeline记录",
+			Buckets: fastBuckets,
+		}, []string{"command"}),
+		redisErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "redis_command_errors_total",
+			Help: "redis命令错误数，不含redis: nil",
+		}, []string{"command"}),
+		dbLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "db_query_duration_seconds",
+			Help:    "gorm语句耗时",
+			Buckets: fastBuckets,
+		}, []string{"operation", "table"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "db_query_errors_total",
+			Help: "gorm语句错误数，不含记录不存在",
+		}, []string{"operation", "table"}),
+		clientLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_client_request_duration_seconds",
+			Help:    "FastResponse请求耗时，请求失败时status为error",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"host", "status"}),
+		clientRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_retries_total",
+			Help: "FastResponse重试次数",
+		}, []string{"host"}),
+		breakerState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_client_breaker_state",
+			Help: "FastResponse熔断状态，0关闭、1熔断、2半开",
+		}, []string{"host"}),
+		breakerReject: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_client_breaker_rejected_total",
+			Help: "熔断期间被拒绝的FastResponse请求数",
+		}, []string{"host"}),
+		limiter: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ratelimit_requests_total",
+			Help: "限流器判定次数，result为allow、deny、error",
+		}, []string{"result"}),
+		cronRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cron_job_runs_total",
+			Help: "定时任务执行次数，status为fail时表示失败",
+		}, []string{"job", "status"}),
+		cronLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cron_job_duration_seconds",
+			Help:    "定时任务耗时",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"job"}),
+	}
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpLatency,
+		m.redisLatency, m.redisErrors,
+		m.dbLatency, m.dbErrors,
+		m.clientLatency, m.clientRetries, m.breakerState, m.breakerReject,
+		m.limiter,
+		m.cronRuns, m.cronLatency,
+	)
+	return m
+}
+
+// GetMetrics 获取默认实例的指标
+func GetMetrics() *Metrics {
+	return std.Metrics()
+}
+
+// Metrics 实例的指标，首次使用时创建
+func (a *App) Metrics() *Metrics {
+	a.metricsOnce.Do(func() {
+		a.metrics = newMetrics()
+	})
+	return a.metrics
+}
+
+// registerMetrics 输出指标的路由
+func (a *App) registerMetrics(r gin.IRouter, path string) {
+	r.GET(path, gin.WrapH(promhttp.HandlerFor(a.Metrics().Registry, promhttp.HandlerOpts{})))
+}
+
+// metricsMiddleware 按路由模板和状态码记录请求，未匹配的路由记为unmatched，避免标签过多
+func metricsMiddleware(m *Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		m.httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		m.httpLatency.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// observeRedis 记录redis命令，忽略的错误不计数
+func (m *Metrics) observeRedis(command string, d time.Duration, err error) {
+	m.redisLatency.WithLabelValues(command).Observe(d.Seconds())
+	if !shouldIgnoreRedisError(err) {
+		m.redisErrors.WithLabelValues(command).Inc()
+	}
+}
+
+// observeClient 记录FastResponse请求
+func (m *Metrics) observeClient(host string, statusCode int, d time.Duration, err error) {
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(statusCode)
+	}
+	m.clientLatency.WithLabelValues(host, status).Observe(d.Seconds())
+}
+
+// observeLimit 记录限流结果
+func (m *Metrics) observeLimit(res *Result, err error) {
+	switch {
+	case err != nil:
+		m.limiter.WithLabelValues("error").Inc()
+	case res.Allowed > 0:
+		m.limiter.WithLabelValues("allow").Inc()
+	default:
+		m.limiter.WithLabelValues("deny").Inc()
+	}
+}
+
+// cronMonitor 实现 gocron.Monitor，按任务名记录
+type cronMonitor struct {
+	m *Metrics
+}
+
+func (c cronMonitor) IncrementJob(_ uuid.UUID, name string, _ []string, status gocron.JobStatus) {
+	c.m.cronRuns.WithLabelValues(name, string(status)).Inc()
+}
+
+func (c cronMonitor) RecordJobTiming(start, end time.Time, _ uuid.UUID, name string, _ []string) {
+	c.m.cronLatency.WithLabelValues(name).Observe(end.Sub(start).Seconds())
+}
+
+// metricsStartKey gorm语句开始时间的键
+const metricsStartKey = "sdq:metrics_start"
+
+// gormMetrics 记录gorm语句耗时的插件
+type gormMetrics struct {
+	m *Metrics
+}
+
+func (p gormMetrics) Name() string {
+	return "sdq:metrics"
+}
+
+func (p gormMetrics) Initialize(db *gorm.DB) error {
+	before := func(db *gorm.DB) {
+		db.InstanceSet(metricsStartKey, time.Now())
+	}
+	after := func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			v, ok := db.InstanceGet(metricsStartKey)
+			if !ok {
+				return
+			}
+			start, ok := v.(time.Time)
+			if !ok {
+				return
+			}
+			table := strings.Trim(db.Statement.Table, "`\"")
+			p.m.dbLatency.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+			if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+				p.m.dbErrors.WithLabelValues(operation, table).Inc()
+			}
+		}
+	}
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("sdq:metrics_before_create", before),
+		cb.Create().After("gorm:create").Register("sdq:metrics_after_create", after("create")),
+		cb.Query().Before("gorm:query").Register("sdq:metrics_before_query", before),
+		cb.Query().After("gorm:query").Register("sdq:metrics_after_query", after("query")),
+		cb.Update().Before("gorm:update").Register("sdq:metrics_before_update", before),
+		cb.Update().After("gorm:update").Register("sdq:metrics_after_update", after("update")),
+		cb.Delete().Before("gorm:delete").Register("sdq:metrics_before_delete", before),
+		cb.Delete().After("gorm:delete").Register("sdq:metrics_after_delete", after("delete")),
+		cb.Row().Before("gorm:row").Register("sdq:metrics_before_row", before),
+		cb.Row().After("gorm:row").Register("sdq:metrics_after_row", after("row")),
+		cb.Raw().Before("gorm:raw").Register("sdq:metrics_before_raw", before),
+		cb.Raw().After("gorm:raw").Register("sdq:metrics_after_raw", after("raw")),
+	)
+}
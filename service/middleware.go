@@ -0,0 +1,481 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sundaqiang/sdq-go/common"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Middleware InitGin 可选中间件，对应 [server.middleware]
+
+	[server.middleware]
+	timeout = "5s"
+	body-limit = 10485760
+	[server.middleware.cors]
+	enable = true
+	allow-origins = ["https://*.example.com"]
+*/
+type Middleware struct {
+	Timeout   time.Duration `toml:"timeout" validate:"min=0"`
+	BodyLimit int64         `toml:"body-limit" validate:"min=0"`
+	Cors      Cors          `toml:"cors"`
+	Gzip      Gzip          `toml:"gzip"`
+	Secure    Secure        `toml:"secure"`
+	AccessLog AccessLog     `toml:"access-log"`
+}
+
+type Cors struct {
+	Enable           bool     `toml:"enable"`
+	AllowOrigins     []string `toml:"allow-origins" default:"*"`
+	AllowMethods     []string `toml:"allow-methods" default:"GET,POST,PUT,PATCH,DELETE,HEAD,OPTIONS"`
+	AllowHeaders     []string `toml:"allow-headers"`
+	ExposeHeaders    []string `toml:"expose-headers"`
+	AllowCredentials bool     `toml:"allow-credentials"`                         // 开启时allow-origins不能为*，需列出具体来源
+	MaxAge           int64    `toml:"max-age" default:"43200" validate:"min=-1"` // -1不缓存预检结果
+}
+
+type Gzip struct {
+	Enable       bool     `toml:"enable"`
+	Level        int      `toml:"level" default:"-1" validate:"min=-1,max=9"`
+	MinLength    int      `toml:"min-length" default:"1024" validate:"min=0"`
+	ExcludePaths []string `toml:"exclude-paths"`
+}
+
+// Secure 安全响应头，零值使用默认值，需要关闭某个响应头时字符串设为"-"、数字设为-1
+type Secure struct {
+	Enable                bool   `toml:"enable"`
+	FrameOptions          string `toml:"frame-options" default:"DENY"`
+	ReferrerPolicy        string `toml:"referrer-policy" default:"strict-origin-when-cross-origin"`
+	ContentSecurityPolicy string `toml:"content-security-policy"`
+	HstsMaxAge            int64  `toml:"hsts-max-age" default:"31536000" validate:"min=-1"`
+}
+
+// GinOption InitGin 的可选项，优先于配置文件
+type GinOption func(o *ginOptions)
+
+type ginOptions struct {
+	addrs      []string
+	middleware Middleware
+	handlers   []gin.HandlerFunc
+}
+
+// newGinOptions 以配置中的中间件为基础应用可选项，可选项传入的零值字段与配置一样使用默认值，关闭需显式设置"-"或-1
+func newGinOptions(server *Server, opts []GinOption) *ginOptions {
+	o := &ginOptions{}
+	if server != nil {
+		o.middleware = server.Middleware
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	_ = common.StructDefault(&o.middleware, "default")
+	return o
+}
+
+// WithAddrs 监听的地址，默认server.host:server.port，端口为0时由系统分配
+func WithAddrs(addrs ...string) GinOption {
+	return func(o *ginOptions) {
+		o.addrs = append(o.addrs, addrs...)
+	}
+}
+
+// WithCors 开启跨域
+func WithCors(cors Cors) GinOption {
+	return func(o *ginOptions) {
+		cors.Enable = true
+		o.middleware.Cors = cors
+	}
+}
+
+// WithGzip 开启响应压缩
+func WithGzip(gz Gzip) GinOption {
+	return func(o *ginOptions) {
+		gz.Enable = true
+		o.middleware.Gzip = gz
+	}
+}
+
+// WithSecure 开启安全响应头
+func WithSecure(secure Secure) GinOption {
+	return func(o *ginOptions) {
+		secure.Enable = true
+		o.middleware.Secure = secure
+	}
+}
+
+// WithTimeout 单个请求的超时时间，通过请求的context传递
+func WithTimeout(timeout time.Duration) GinOption {
+	return func(o *ginOptions) {
+		o.middleware.Timeout = timeout
+	}
+}
+
+// WithBodyLimit 请求体的最大字节数
+func WithBodyLimit(limit int64) GinOption {
+	return func(o *ginOptions) {
+		o.middleware.BodyLimit = limit
+	}
+}
+
+// WithAccessLog 请求日志的记录范围，零值字段使用 DefaultAccessLog 的值
+func WithAccessLog(access AccessLog) GinOption {
+	return func(o *ginOptions) {
+		o.middleware.AccessLog = access
+	}
+}
+
+// WithHandlers 追加自定义中间件，位于内置中间件之后、路由之前
+func WithHandlers(handlers ...gin.HandlerFunc) GinOption {
+	return func(o *ginOptions) {
+		o.handlers = append(o.handlers, handlers...)
+	}
+}
+
+// maxTraceIdLen 上游链路id的最大长度
+const maxTraceIdLen = 128
+
+/*
+traceHeaderMiddleware 校验上游传入的链路id，不合法时删除，由requestid重新生成
+
+	只接受字母、数字和 - _ . :，避免日志注入
+*/
+func traceHeaderMiddleware(header string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id := c.GetHeader(header); id != "" && !validTraceId(id) {
+			c.Request.Header.Del(header)
+		}
+		c.Next()
+	}
+}
+
+// validTraceId 链路id是否可以直接使用
+func validTraceId(id string) bool {
+	if len(id) > maxTraceIdLen {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// secureMiddleware 设置常用的安全响应头，HSTS只在TLS请求上设置
+func secureMiddleware(conf Secure) gin.HandlerFunc {
+	hsts := "max-age=" + strconv.FormatInt(conf.HstsMaxAge, 10) + "; includeSubDomains"
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		if conf.FrameOptions != "" && conf.FrameOptions != "-" {
+			h.Set("X-Frame-Options", conf.FrameOptions)
+		}
+		if conf.ReferrerPolicy != "" && conf.ReferrerPolicy != "-" {
+			h.Set("Referrer-Policy", conf.ReferrerPolicy)
+		}
+		if conf.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", conf.ContentSecurityPolicy)
+		}
+		if conf.HstsMaxAge > 0 && c.Request.TLS != nil {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		c.Next()
+	}
+}
+
+/*
+corsMiddleware 跨域，预检请求直接返回
+
+	allow-origins支持*和*.example.com形式的通配，allow-credentials开启时忽略单独的*
+*/
+func corsMiddleware(conf Cors, trace string) gin.HandlerFunc {
+	allowAll := false
+	origins := make([]string, 0, len(conf.AllowOrigins))
+	for _, v := range conf.AllowOrigins {
+		if v != "*" {
+			origins = append(origins, v)
+		} else if !conf.AllowCredentials {
+			allowAll = true
+		}
+	}
+	conf.AllowOrigins = origins
+	methods := strings.Join(conf.AllowMethods, ",")
+	// 让前端能传入链路id
+	allow := conf.AllowHeaders
+	if trace != "" && len(allow) > 0 {
+		allow = append(allow[:len(allow):len(allow)], trace)
+	}
+	headers := strings.Join(allow, ",")
+	// 让前端能读到链路id
+	expose := conf.ExposeHeaders
+	if trace != "" {
+		expose = append(expose[:len(expose):len(expose)], trace)
+	}
+	exposeHeaders := strings.Join(expose, ",")
+	maxAge := strconv.FormatInt(conf.MaxAge, 10)
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		h := c.Writer.Header()
+		h.Add("Vary", "Origin")
+		if !allowAll && !matchOrigin(conf.AllowOrigins, origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+		if allowAll {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if conf.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			if exposeHeaders != "" {
+				h.Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", methods)
+		if headers != "" {
+			h.Set("Access-Control-Allow-Headers", headers)
+		} else if req := c.GetHeader("Access-Control-Request-Headers"); req != "" {
+			// 未配置时允许请求的全部头
+			h.Set("Access-Control-Allow-Headers", req)
+		}
+		if conf.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// matchOrigin 匹配来源，支持一个*通配
+func matchOrigin(origins []string, origin string) bool {
+	for _, v := range origins {
+		if prefix, suffix, ok := strings.Cut(v, "*"); ok {
+			if len(origin) >= len(prefix)+len(suffix) &&
+				strings.HasPrefix(origin, prefix) &&
+				strings.HasSuffix(origin, suffix) {
+				return true
+			}
+			continue
+		}
+		if strings.EqualFold(v, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyLimitMiddleware 限制请求体大小，声明的长度超限时直接返回413
+func bodyLimitMiddleware(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			GetGinTracer(c).GetHttpResFailure(http.StatusRequestEntityTooLarge, http.StatusRequestEntityTooLarge, "请求体过大")
+			return
+		}
+		if c.Request.Body != nil {
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		}
+		c.Next()
+	}
+}
+
+/*
+timeoutMiddleware 给请求的context设置超时
+
+	处理函数需要通过context感知超时，超时且未写入响应时返回504
+*/
+func timeoutMiddleware(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Writer.Written() {
+			GetGinTracer(c).GetHttpResFailure(http.StatusGatewayTimeout, http.StatusGatewayTimeout, "请求超时")
+		}
+	}
+}
+
+// 不压缩的响应类型
+var gzipSkipTypes = []string{
+	"image/", "video/", "audio/", "text/event-stream",
+	"application/zip", "application/gzip", "application/octet-stream",
+}
+
+// gzipMiddleware 压缩响应，小于min-length的响应不压缩
+func gzipMiddleware(conf Gzip) gin.HandlerFunc {
+	exclude := make(map[string]bool, len(conf.ExcludePaths))
+	for _, v := range conf.ExcludePaths {
+		exclude[v] = true
+	}
+	pool := &sync.Pool{
+		New: func() any {
+			w, _ := gzip.NewWriterLevel(nil, conf.Level)
+			return w
+		},
+	}
+	return func(c *gin.Context) {
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") ||
+			c.Request.Method == http.MethodHead ||
+			exclude[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+		w := &gzipWriter{ResponseWriter: c.Writer, pool: pool, minLength: conf.MinLength, size: -1}
+		c.Writer = w
+		defer func() {
+			w.finish()
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+/*
+gzipWriter 先缓存到min-length再决定是否压缩
+
+	缓存期间响应头尚未发出，Written、Size按处理函数已写入的内容返回，状态码不再允许修改
+*/
+type gzipWriter struct {
+	gin.ResponseWriter
+	pool      *sync.Pool
+	minLength int
+	buf       []byte
+	gz        *gzip.Writer
+	skip      bool
+	size      int // 处理函数写入的未压缩字节数，-1为未写入
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.Written() {
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// WriteHeaderNow 只锁定状态码，响应头等决定是否压缩后再发出
+func (w *gzipWriter) WriteHeaderNow() {
+	if w.size < 0 {
+		w.size = 0
+	}
+}
+
+func (w *gzipWriter) Written() bool {
+	return w.size >= 0 || w.ResponseWriter.Written()
+}
+
+func (w *gzipWriter) Size() int {
+	if w.size >= 0 {
+		return w.size
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(b)
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	if w.skip {
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.minLength {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush 流式响应时立即决定是否压缩
+func (w *gzipWriter) Flush() {
+	if w.gz == nil && !w.skip {
+		_ = w.start(true)
+	}
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// start 根据响应头决定是否压缩，并写出缓存
+func (w *gzipWriter) start(compress bool) error {
+	h := w.Header()
+	if compress && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		h.Del("Content-Length")
+		w.gz = w.pool.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	} else {
+		w.skip = true
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// finish 写出未达到min-length的缓存，关闭压缩流
+func (w *gzipWriter) finish() {
+	if w.gz == nil && !w.skip {
+		_ = w.start(false)
+	}
+	if w.size >= 0 && !w.ResponseWriter.Written() {
+		// 只设置了状态码没有响应体
+		w.ResponseWriter.WriteHeaderNow()
+	}
+	if w.gz != nil {
+		_ = w.gz.Close()
+		w.pool.Put(w.gz)
+		w.gz = nil
+	}
+}
+
+func compressible(contentType string) bool {
+	for _, v := range gzipSkipTypes {
+		if strings.HasPrefix(contentType, v) {
+			return false
+		}
+	}
+	return true
+}
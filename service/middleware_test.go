@@ -0,0 +1,218 @@
+package service
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestEngine 按conf创建实例和gin，不监听端口
+func newTestEngine(t *testing.T, conf *Config, router func(r *gin.Engine), opts ...GinOption) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	a, err := NewApp(testConfig(t, conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := a.newGin(router, nil, newGinOptions(a.Config().Server, opts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func serve(r http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// readBody 按Content-Encoding解压
+func readBody(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body io.Reader = w.Body
+	if w.Header().Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = gz
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func gzipRequest(path string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	return req
+}
+
+func TestGzipCompressesLargeResponse(t *testing.T) {
+	big := strings.Repeat("a", 4096)
+	r := newTestEngine(t, &Config{}, func(r *gin.Engine) {
+		r.GET("big", func(c *gin.Context) { c.String(http.StatusOK, big) })
+		r.GET("small", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	}, WithGzip(Gzip{}))
+
+	w := serve(r, gzipRequest("/big"))
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("大响应未压缩: %v", w.Header())
+	}
+	if got := readBody(t, w); got != big {
+		t.Fatalf("解压后内容不一致, 长度%d", len(got))
+	}
+
+	w = serve(r, gzipRequest("/small"))
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("小于min-length的响应不应压缩: %v", w.Header())
+	}
+	if got := readBody(t, w); got != "ok" {
+		t.Fatalf("body = %q", got)
+	}
+}
+
+func TestGzipReportsBufferedWrite(t *testing.T) {
+	r := newTestEngine(t, &Config{}, func(r *gin.Engine) {
+		r.GET("handle", Handle(func(t *GinTracer, req *struct{}) (string, error) {
+			t.Ctx.String(http.StatusOK, "ok")
+			return "dup", nil
+		}))
+		r.GET("twice", func(c *gin.Context) {
+			c.String(http.StatusOK, "first")
+			if !c.Writer.Written() || c.Writer.Size() != len("first") {
+				t.Errorf("缓存的写入未被报告: written=%v size=%d", c.Writer.Written(), c.Writer.Size())
+			}
+			c.Status(http.StatusInternalServerError)
+		})
+	}, WithGzip(Gzip{}))
+
+	w := serve(r, gzipRequest("/handle"))
+	if got := readBody(t, w); got != "ok" {
+		t.Fatalf("Handle重复输出: %q", got)
+	}
+
+	w = serve(r, gzipRequest("/twice"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("写入后状态码被修改为%d", w.Code)
+	}
+}
+
+func TestGzipStatusWithoutBody(t *testing.T) {
+	r := newTestEngine(t, &Config{}, func(r *gin.Engine) {
+		r.GET("empty", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	}, WithGzip(Gzip{}))
+	w := serve(r, gzipRequest("/empty"))
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Fatalf("code=%d body=%q", w.Code, w.Body.String())
+	}
+}
+
+func corsRequest(method, origin string) *http.Request {
+	req := httptest.NewRequest(method, "/ping", nil)
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	}
+	return req
+}
+
+func pingRouter(r *gin.Engine) {
+	r.GET("ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+}
+
+func TestCorsDefaultAllowsAnyOrigin(t *testing.T) {
+	r := newTestEngine(t, &Config{}, pingRouter, WithCors(Cors{}))
+	w := serve(r, corsRequest(http.MethodOptions, "https://a.example"))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("预检返回%d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("Allow-Origin = %q", got)
+	}
+	if w.Header().Get("Access-Control-Allow-Methods") == "" {
+		t.Fatal("缺少Allow-Methods")
+	}
+}
+
+func TestCorsCredentialsRejectWildcard(t *testing.T) {
+	conf := &Config{Server: &Server{Middleware: Middleware{Cors: Cors{Enable: true, AllowCredentials: true}}}}
+	err := ValidateConfig(conf)
+	var configErr *ConfigError
+	if !errors.As(err, &configErr) || configErr.Fields[0].Key != "server.middleware.cors.allow-origins" {
+		t.Fatalf("allow-credentials搭配*应校验失败: %v", err)
+	}
+
+	a, err := NewApp(testConfig(t, &Config{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.newGin(pingRouter, nil, newGinOptions(a.Config().Server, []GinOption{WithCors(Cors{AllowCredentials: true})}))
+	if !errors.As(err, &configErr) {
+		t.Fatalf("通过选项传入时也应校验失败: %v", err)
+	}
+}
+
+func TestCorsCredentialsExplicitOrigins(t *testing.T) {
+	r := newTestEngine(t, &Config{}, pingRouter, WithCors(Cors{
+		AllowOrigins:     []string{"https://*.example.com"},
+		AllowCredentials: true,
+	}))
+	w := serve(r, corsRequest(http.MethodGet, "https://app.example.com"))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Fatalf("Allow-Origin = %q", got)
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+		t.Fatal("缺少Allow-Credentials")
+	}
+
+	w = serve(r, corsRequest(http.MethodGet, "https://evil.example"))
+	if w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Fatalf("未允许的来源得到了跨域头: %v", w.Header())
+	}
+	if w = serve(r, corsRequest(http.MethodOptions, "https://evil.example")); w.Code != http.StatusForbidden {
+		t.Fatalf("未允许来源的预检返回%d", w.Code)
+	}
+}
+
+func TestCorsMiddlewareIgnoresWildcardWithCredentials(t *testing.T) {
+	r := gin.New()
+	r.Use(corsMiddleware(Cors{AllowOrigins: []string{"*"}, AllowCredentials: true}, ""))
+	pingRouter(r)
+	w := serve(r, corsRequest(http.MethodGet, "https://evil.example"))
+	if w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("凭证模式下*不应放行: %v", w.Header())
+	}
+}
+
+func TestSecureDefaultsAndDisable(t *testing.T) {
+	// https地址的请求带TLS信息，才会发送HSTS
+	tlsRequest := func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "https://a.example/ping", nil)
+	}
+	r := newTestEngine(t, &Config{}, pingRouter, WithSecure(Secure{}))
+	w := serve(r, tlsRequest())
+	if w.Header().Get("X-Frame-Options") != "DENY" || w.Header().Get("Strict-Transport-Security") == "" {
+		t.Fatalf("零值应使用默认值: %v", w.Header())
+	}
+
+	r = newTestEngine(t, &Config{}, pingRouter, WithSecure(Secure{FrameOptions: "-", ReferrerPolicy: "-", HstsMaxAge: -1}))
+	w = serve(r, tlsRequest())
+	for _, k := range []string{"X-Frame-Options", "Referrer-Policy", "Strict-Transport-Security"} {
+		if v := w.Header().Get(k); v != "" {
+			t.Fatalf("%s应关闭, 实际为%q", k, v)
+		}
+	}
+	if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatal("缺少X-Content-Type-Options")
+	}
+}
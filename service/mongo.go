@@ -7,13 +7,12 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
-	"go.uber.org/zap"
 )
 
 type Mongo struct {
-	Url            string `toml:"url"`
+	Url            string `toml:"url" validate:"omitempty,uri"`
 	Username       string `toml:"username"`
-	Password       string `toml:"password"`
+	Password       string `toml:"password" secret:"true"`
 	AppName        string `toml:"app-name"`
 	PreferenceMode string `toml:"preference-mode"`
 	MaxPool        uint64 `toml:"max-pool"`
@@ -21,7 +20,14 @@ type Mongo struct {
 }
 
 // InitMongo 初始化
-func InitMongo(info *Mongo) {
+func InitMongo(info *Mongo) error {
+	return withStd(func(a *App) error {
+		return a.InitMongo(info)
+	})
+}
+
+// InitMongo 初始化
+func (a *App) InitMongo(info *Mongo) error {
 	var err error
 	ctx := context.Background()
 	// 连接实例
@@ -48,6 +54,8 @@ func InitMongo(info *Mongo) {
 			Password: info.Password,
 		})
 
+	opts = opts.SetMonitor((&mongoMonitor{app: a}).monitor())
+
 	if info.AppName != "" {
 		opts = opts.SetAppName(info.AppName)
 	}
@@ -67,16 +75,20 @@ func InitMongo(info *Mongo) {
 		opts = opts.SetMinPoolSize(info.MinPool)
 	}
 
-	Mdb, err = mongo.Connect(ctx, opts)
+	a.Mdb, err = mongo.Connect(ctx, opts)
 	if err != nil {
-		ZapLog.Fatal("mongo连接失败", zap.Error(err))
-		return
+		return newInitError(SubsystemMongo, err)
 	}
+	mdb := a.Mdb
+	a.onClose(SubsystemMongo, mdb.Disconnect)
+	a.onHealth(SubsystemMongo, SubsystemMongo, func(ctx context.Context) error {
+		return mdb.Ping(ctx, readpref.Primary())
+	})
 
 	// 是否连接检测
-	if err = Mdb.Ping(ctx, readpref.Primary()); err != nil {
-		ZapLog.Fatal("mongo连接错误", zap.Error(err))
-	} else {
-		ZapLog.Info("mongo连接成功")
+	if err = a.Mdb.Ping(ctx, readpref.Primary()); err != nil {
+		return newInitError(SubsystemMongo, err)
 	}
+	a.Log.Info("mongo连接成功")
+	return nil
 }
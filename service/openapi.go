@@ -0,0 +1,528 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"html"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenAPI 由 Route 注册的路由生成的OpenAPI 3文档
+type OpenAPI struct {
+	Title       string
+	Version     string
+	Description string
+	// Assets 文档页面加载Swagger UI的地址，默认unpkg.com，离线或内网部署时改为自行托管的swagger-ui-dist
+	Assets string
+
+	app    *App
+	mu     sync.Mutex
+	routes []*apiRoute
+}
+
+// apiRoute 注册的路由及其请求和返回类型
+type apiRoute struct {
+	method      string
+	path        string
+	summary     string
+	description string
+	tags        []string
+	req         reflect.Type
+	resp        reflect.Type
+}
+
+// RouteOption 路由的文档信息
+type RouteOption func(r *apiRoute)
+
+// WithSummary 接口摘要
+func WithSummary(summary string) RouteOption {
+	return func(r *apiRoute) {
+		r.summary = summary
+	}
+}
+
+// WithDescription 接口说明
+func WithDescription(description string) RouteOption {
+	return func(r *apiRoute) {
+		r.description = description
+	}
+}
+
+// WithTags 接口分组
+func WithTags(tags ...string) RouteOption {
+	return func(r *apiRoute) {
+		r.tags = append(r.tags, tags...)
+	}
+}
+
+// Docs 默认实例的文档
+func Docs() *OpenAPI {
+	return std.OpenAPI()
+}
+
+// OpenAPI 实例的文档，标题默认为server.name
+func (a *App) OpenAPI() *OpenAPI {
+	a.docsOnce.Do(func() {
+		title := "API"
+		if server := a.Config().Server; server != nil && server.Name != "" {
+			title = server.Name
+		}
+		a.docs = &OpenAPI{Title: title, Version: "1.0.0", Assets: defaultDocsAssets, app: a}
+	})
+	return a.docs
+}
+
+/*
+Route 使用 Handle 注册路由并记录到文档
+
+	请求参数按uri、header、form、json标签生成，binding标签生成必填和取值范围，msg标签作为字段说明
+	service.Route(app.OpenAPI(), r, http.MethodGet, "user/:id", getUser, service.WithSummary("用户详情"))
+*/
+func Route[Req, Resp any](doc *OpenAPI, r gin.IRouter, method, path string, fn func(t *GinTracer, req *Req) (Resp, error), opts ...RouteOption) {
+	r.Handle(method, path, Handle(fn))
+	if b, ok := r.(interface{ BasePath() string }); ok {
+		path = joinPath(b.BasePath(), path)
+	}
+	route := &apiRoute{
+		method: strings.ToLower(method),
+		path:   ginPathToOpenAPI(path),
+		req:    reflect.TypeOf((*Req)(nil)).Elem(),
+		resp:   reflect.TypeOf((*Resp)(nil)).Elem(),
+	}
+	for _, opt := range opts {
+		opt(route)
+	}
+	doc.mu.Lock()
+	doc.routes = append(doc.routes, route)
+	doc.mu.Unlock()
+}
+
+func joinPath(base, path string) string {
+	return "/" + strings.Trim(strings.TrimSuffix(base, "/")+"/"+strings.TrimPrefix(path, "/"), "/")
+}
+
+var ginParam = regexp.MustCompile(`[:*]([^/]+)`)
+
+// ginPathToOpenAPI 把 /user/:id 转为 /user/{id}
+func ginPathToOpenAPI(path string) string {
+	return ginParam.ReplaceAllString(path, "{$1}")
+}
+
+// Spec 生成文档
+func (d *OpenAPI) Spec() map[string]any {
+	d.mu.Lock()
+	routes := make([]*apiRoute, len(d.routes))
+	copy(routes, d.routes)
+	d.mu.Unlock()
+	s := &schemaBuilder{schemas: map[string]any{}, names: map[reflect.Type]string{}, owners: map[string]reflect.Type{}}
+	paths := map[string]any{}
+	for _, r := range routes {
+		item, ok := paths[r.path].(map[string]any)
+		if !ok {
+			item = map[string]any{}
+			paths[r.path] = item
+		}
+		item[r.method] = d.operation(s, r)
+	}
+	info := map[string]any{"title": d.Title, "version": d.Version}
+	if d.Description != "" {
+		info["description"] = d.Description
+	}
+	return map[string]any{
+		"openapi":    "3.0.3",
+		"info":       info,
+		"paths":      paths,
+		"components": map[string]any{"schemas": s.schemas},
+	}
+}
+
+// operation 生成单个接口
+func (d *OpenAPI) operation(s *schemaBuilder, r *apiRoute) map[string]any {
+	op := map[string]any{}
+	if r.summary != "" {
+		op["summary"] = r.summary
+	}
+	if r.description != "" {
+		op["description"] = r.description
+	}
+	if len(r.tags) > 0 {
+		op["tags"] = r.tags
+	}
+	var params []any
+	jsonBody := newObjectSchema()
+	formBody := newObjectSchema()
+	multipartForm := false
+	query := r.method == "get" || r.method == "delete" || r.method == "head"
+	// 与 Handle 一致，只有需要绑定json时才生成请求体
+	bindJson := detectSources(r.req)&sourceJson != 0
+	for _, f := range structFields(r.req) {
+		schema := s.fieldSchema(f)
+		required := hasRule(f, "required")
+		if name := tagName(f, "uri"); name != "" {
+			params = append(params, map[string]any{"name": name, "in": "path", "required": true, "schema": schema})
+			continue
+		}
+		if name := tagName(f, "header"); name != "" {
+			params = append(params, map[string]any{"name": name, "in": "header", "required": required, "schema": schema})
+			continue
+		}
+		if name := tagName(f, "form"); name != "" {
+			if query {
+				params = append(params, map[string]any{"name": name, "in": "query", "required": required, "schema": schema})
+			} else {
+				formBody.add(name, schema, required)
+				multipartForm = multipartForm || derefType(f.Type) == fileHeaderType ||
+					derefType(f.Type).Kind() == reflect.Slice && derefType(derefType(f.Type).Elem()) == fileHeaderType
+			}
+			continue
+		}
+		if name := jsonName(f); name != "" && bindJson {
+			jsonBody.add(name, schema, required)
+		}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	content := map[string]any{}
+	if len(jsonBody.properties) > 0 {
+		content[binding.MIMEJSON] = map[string]any{"schema": jsonBody.schema()}
+	}
+	if len(formBody.properties) > 0 {
+		mime := "application/x-www-form-urlencoded"
+		if multipartForm {
+			mime = "multipart/form-data"
+		}
+		content[mime] = map[string]any{"schema": formBody.schema()}
+	}
+	if len(content) > 0 {
+		op["requestBody"] = map[string]any{"content": content}
+	}
+	op["responses"] = map[string]any{
+		"200": map[string]any{
+			"description": "成功",
+			"content":     map[string]any{binding.MIMEJSON: map[string]any{"schema": d.envelope(s, r.resp, true)}},
+		},
+		"default": map[string]any{
+			"description": "失败",
+			"content":     map[string]any{binding.MIMEJSON: map[string]any{"schema": d.envelope(s, nil, false)}},
+		},
+	}
+	return op
+}
+
+// envelope 按 ResponseFormat 生成返回结构
+func (d *OpenAPI) envelope(s *schemaBuilder, data reflect.Type, success bool) map[string]any {
+	f := d.app.responseFormat()
+	obj := newObjectSchema()
+	if f.Success != "" {
+		obj.add(f.Success, map[string]any{"type": "boolean"}, true)
+	}
+	if f.Code != "" {
+		obj.add(f.Code, map[string]any{"type": "integer"}, true)
+	}
+	if trace := d.app.trace(); trace != "" {
+		obj.add(trace, map[string]any{"type": "string"}, true)
+	}
+	if !success {
+		if f.Msg != "" {
+			obj.add(f.Msg, map[string]any{}, true)
+		}
+		return obj.schema()
+	}
+	if data != nil && data.Implements(pagedType) {
+		if list, ok := derefType(data).FieldByName("List"); ok {
+			data = list.Type
+		}
+		if f.Page != "" {
+			obj.add(f.Page, s.schemaOf(reflect.TypeOf(Page{})), true)
+		}
+	}
+	if f.Data != "" && data != nil {
+		obj.add(f.Data, s.schemaOf(data), true)
+	}
+	return obj.schema()
+}
+
+var (
+	pagedType      = reflect.TypeOf((*pagedResult)(nil)).Elem()
+	timeType       = reflect.TypeOf(time.Time{})
+	fileHeaderType = reflect.TypeOf(multipart.FileHeader{})
+)
+
+// objectSchema 收集对象的属性和必填字段
+type objectSchema struct {
+	properties map[string]any
+	required   []string
+}
+
+func newObjectSchema() *objectSchema {
+	return &objectSchema{properties: map[string]any{}}
+}
+
+func (o *objectSchema) add(name string, schema map[string]any, required bool) {
+	o.properties[name] = schema
+	if required {
+		o.required = append(o.required, name)
+	}
+}
+
+func (o *objectSchema) schema() map[string]any {
+	m := map[string]any{"type": "object", "properties": o.properties}
+	if len(o.required) > 0 {
+		m["required"] = o.required
+	}
+	return m
+}
+
+// schemaBuilder 生成类型的schema，具名结构体放到components中
+type schemaBuilder struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+	owners  map[string]reflect.Type
+}
+
+var schemaNameInvalid = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+func (s *schemaBuilder) schemaOf(t reflect.Type) map[string]any {
+	t = derefType(t)
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case fileHeaderType:
+		return map[string]any{"type": "string", "format": "binary"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.structSchema(t)
+		}
+		name := s.schemaName(t)
+		if _, ok := s.schemas[name]; !ok {
+			// 先占位，避免递归类型死循环
+			s.schemas[name] = map[string]any{}
+			s.schemas[name] = s.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]any{}
+}
+
+/*
+schemaName 具名结构体在components中的名称
+
+	默认使用类型名，与已使用的同名类型冲突时带上包路径，如 ListReq 与 example.com_order.ListReq
+*/
+func (s *schemaBuilder) schemaName(t reflect.Type) string {
+	if name, ok := s.names[t]; ok {
+		return name
+	}
+	name := schemaNameInvalid.ReplaceAllString(t.Name(), "_")
+	if owner, ok := s.owners[name]; ok && owner != t {
+		qualified := schemaNameInvalid.ReplaceAllString(t.PkgPath()+"."+t.Name(), "_")
+		name = qualified
+		for i := 2; s.owners[name] != nil; i++ {
+			name = qualified + "_" + strconv.Itoa(i)
+		}
+	}
+	s.names[t] = name
+	s.owners[name] = t
+	return name
+}
+
+// structSchema 按json标签生成对象
+func (s *schemaBuilder) structSchema(t reflect.Type) map[string]any {
+	obj := newObjectSchema()
+	for _, f := range structFields(t) {
+		if name := jsonName(f); name != "" {
+			obj.add(name, s.fieldSchema(f), hasRule(f, "required"))
+		}
+	}
+	return obj.schema()
+}
+
+// ruleLimits binding规则对应数字、字符串、数组的限制
+var ruleLimits = map[string][3]string{
+	"min": {"minimum", "minLength", "minItems"},
+	"gte": {"minimum", "minLength", "minItems"},
+	"max": {"maximum", "maxLength", "maxItems"},
+	"lte": {"maximum", "maxLength", "maxItems"},
+}
+
+// fieldSchema 字段的schema，附加binding规则和msg说明
+func (s *schemaBuilder) fieldSchema(f reflect.StructField) map[string]any {
+	schema := s.schemaOf(f.Type)
+	if _, ok := schema["$ref"]; ok {
+		return schema
+	}
+	if msg := f.Tag.Get("msg"); msg != "" {
+		schema["description"] = msg
+	}
+	numeric := schema["type"] == "integer" || schema["type"] == "number"
+	for _, rule := range strings.Split(f.Tag.Get("binding"), ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		switch key {
+		case "min", "gte", "max", "lte", "len":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			if key == "len" {
+				if !numeric {
+					schema["minLength"], schema["maxLength"] = n, n
+				}
+				continue
+			}
+			limit := ruleLimits[key]
+			if numeric {
+				schema[limit[0]] = n
+			} else if schema["type"] == "array" {
+				schema[limit[2]] = n
+			} else {
+				schema[limit[1]] = n
+			}
+		case "oneof":
+			var enum []any
+			for _, v := range strings.Fields(value) {
+				if numeric {
+					if n, err := strconv.ParseFloat(v, 64); err == nil {
+						enum = append(enum, n)
+					}
+					continue
+				}
+				enum = append(enum, v)
+			}
+			schema["enum"] = enum
+		case "email":
+			schema["format"] = "email"
+		case "url", "uri":
+			schema["format"] = "uri"
+		}
+	}
+	return schema
+}
+
+// structFields 展开匿名嵌入后的导出字段
+func structFields(t reflect.Type) []reflect.StructField {
+	t = derefType(t)
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Tag == "" && derefType(f.Type).Kind() == reflect.Struct {
+			fields = append(fields, structFields(f.Type)...)
+			continue
+		}
+		if f.IsExported() {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// tagName 标签中的名称，"-"视为没有
+func tagName(f reflect.StructField, tag string) string {
+	name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// jsonName 没有json标签时使用字段名
+func jsonName(f reflect.StructField) string {
+	v, ok := f.Tag.Lookup("json")
+	if !ok {
+		return f.Name
+	}
+	name, _, _ := strings.Cut(v, ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func hasRule(f reflect.StructField, rule string) bool {
+	for _, v := range strings.Split(f.Tag.Get("binding"), ",") {
+		if v == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultDocsAssets 默认从unpkg.com加载Swagger UI，需要访问外网
+const defaultDocsAssets = "https://unpkg.com/swagger-ui-dist@5"
+
+// docsPage 使用Swagger UI展示文档，静态资源取自 OpenAPI.Assets
+const docsPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{title}}</title>
+<link rel="stylesheet" href="{{assets}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="{{assets}}/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "{{url}}", dom_id: "#swagger-ui"});</script>
+</body>
+</html>`
+
+// registerDocs 在path注册文档页面，在path/openapi.json注册文档
+func (a *App) registerDocs(r *gin.Engine, path string) {
+	path = "/" + strings.Trim(path, "/")
+	specPath := strings.TrimSuffix(path, "/") + "/openapi.json"
+	doc := a.OpenAPI()
+	r.GET(specPath, func(c *gin.Context) {
+		c.JSON(http.StatusOK, doc.Spec())
+	})
+	r.GET(path, func(c *gin.Context) {
+		assets := strings.TrimSuffix(doc.Assets, "/")
+		if assets == "" {
+			assets = defaultDocsAssets
+		}
+		page := strings.NewReplacer(
+			"{{title}}", html.EscapeString(doc.Title),
+			"{{assets}}", html.EscapeString(assets),
+			"{{url}}", specPath,
+		).Replace(docsPage)
+		c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page))
+	})
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyFunc 从请求中提取限流的键，返回空字符串时不限流
+type KeyFunc func(c *gin.Context) string
+
+// KeyByIP 按客户端Ip限流
+func KeyByIP() KeyFunc {
+	return func(c *gin.Context) string {
+		return "ip:" + c.ClientIP()
+	}
+}
+
+// KeyByHeader 按请求头限流，例如 X-Api-Key
+func KeyByHeader(name string) KeyFunc {
+	return func(c *gin.Context) string {
+		v := c.GetHeader(name)
+		if v == "" {
+			return ""
+		}
+		return "header:" + name + ":" + v
+	}
+}
+
+// KeyByUser 按已认证的用户限流，key为鉴权中间件通过c.Set保存用户标识的键
+func KeyByUser(key string) KeyFunc {
+	return func(c *gin.Context) string {
+		v, ok := c.Get(key)
+		if !ok || v == nil {
+			return ""
+		}
+		return "user:" + fmt.Sprint(v)
+	}
+}
+
+// KeyByRoute 按路由限流，所有客户端共享额度
+func KeyByRoute() KeyFunc {
+	return func(c *gin.Context) string {
+		return "route:" + c.Request.Method + ":" + c.FullPath()
+	}
+}
+
+/*
+KeyJoin 组合多个键，任一为空时不限流
+
+	service.KeyJoin(service.KeyByRoute(), service.KeyByIP())
+*/
+func KeyJoin(fns ...KeyFunc) KeyFunc {
+	return func(c *gin.Context) string {
+		keys := make([]string, len(fns))
+		for i, fn := range fns {
+			if keys[i] = fn(c); keys[i] == "" {
+				return ""
+			}
+		}
+		return strings.Join(keys, "|")
+	}
+}
+
+/*
+RateLimit 限流中间件，使用请求所属实例的Limiter
+
+	r.POST("login", service.RateLimit(service.PerMinute(10), service.KeyByIP()), login)
+	超出额度时按 ErrTooManyRequests 返回，未初始化限流器或redis异常时放行
+*/
+func RateLimit(limit Limit, key KeyFunc) gin.HandlerFunc {
+	limitHeader := strconv.Itoa(limit.Rate)
+	return func(c *gin.Context) {
+		t := GetGinTracer(c)
+		k := key(c)
+		if k == "" || t.app.Limiter == nil {
+			c.Next()
+			return
+		}
+		res, err := t.app.Limiter.Allow(c, "gin:"+k, limit)
+		if err != nil {
+			t.Log.Error("限流失败", zap.String("key", k), zap.Error(err))
+			c.Next()
+			return
+		}
+		h := c.Writer.Header()
+		h.Set("X-RateLimit-Limit", limitHeader)
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("X-RateLimit-Reset", ceilSeconds(res.ResetAfter))
+		if res.Allowed == 0 {
+			h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+			t.Fail(ErrTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
+
+// ceilSeconds 向上取整的秒数
+func ceilSeconds(d time.Duration) string {
+	if d < 0 {
+		return "0"
+	}
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -2,26 +2,37 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"strings"
 	"time"
 
-	"github.com/gin-contrib/requestid"
-	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"go.uber.org/zap"
 )
 
 type Redis struct {
-	Network  string `toml:"network"`
-	Addr     string `toml:"addr"`
+	Network  string `toml:"network" default:"tcp" validate:"oneof=tcp unix"`
+	Addr     string `toml:"addr" validate:"required"`
 	Username string `toml:"username"`
-	Password string `toml:"password"`
-	DB       int    `toml:"db"`
+	Password string `toml:"password" secret:"true"`
+	DB       int    `toml:"db" validate:"min=0"`
 }
 
-type LogHook struct{}
+// LogHook redis日志钩子，零值使用默认实例
+type LogHook struct {
+	app *App
+}
+
+func (h LogHook) owner() *App {
+	if h.app != nil {
+		return h.app
+	}
+	return std
+}
 
 var ignoredRedisErrorSubstrings = []string{
 	"redis: nil",
@@ -46,31 +57,34 @@ func shouldIgnoreRedisError(err error) bool {
 	return false
 }
 
-func (LogHook) DialHook(next redis.DialHook) redis.DialHook {
+func (h LogHook) DialHook(next redis.DialHook) redis.DialHook {
 	return func(ctx context.Context, network, addr string) (net.Conn, error) {
 		return next(ctx, network, addr)
 	}
 }
 
-func (LogHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+func (h LogHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
 	return func(ctx context.Context, cmd redis.Cmder) error {
-		traceID := ""
-		if c, ok := ctx.(*gin.Context); ok {
-			traceID = requestid.Get(c)
-		} else if v := ctx.Value(config.Server.Trace); v != nil {
-			if s, ok := v.(string); ok {
-				traceID = s
-			}
-		}
-
+		a := h.owner()
+		l := a.traceLogger(ctx)
+
+		ctx, span := a.tracer().Start(ctx, "redis."+cmd.Name(),
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "redis"),
+				attribute.String("db.operation.name", cmd.Name()),
+			),
+		)
+		start := time.Now()
 		err := next(ctx, cmd)
 		if err == nil {
 			err = cmd.Err()
 		}
-
-		l := ZapLog
-		if traceID != "" {
-			l = l.With(zap.String(config.Server.Trace, traceID))
+		a.Metrics().observeRedis(cmd.Name(), time.Since(start), err)
+		if shouldIgnoreRedisError(err) {
+			endSpan(span, nil)
+		} else {
+			endSpan(span, err)
 		}
 		if !shouldIgnoreRedisError(err) {
 			l.Error(
@@ -89,22 +103,26 @@ func (LogHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
 	}
 }
 
-func (LogHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+func (h LogHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
 	return func(ctx context.Context, cmds []redis.Cmder) error {
-		traceID := ""
-		if c, ok := ctx.(*gin.Context); ok {
-			traceID = requestid.Get(c)
-		} else if v := ctx.Value(config.Server.Trace); v != nil {
-			if s, ok := v.(string); ok {
-				traceID = s
-			}
-		}
-
+		a := h.owner()
+		l := a.traceLogger(ctx)
+
+		ctx, span := a.tracer().Start(ctx, "redis.pipeline",
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "redis"),
+				attribute.String("db.operation.name", "pipeline"),
+				attribute.Int("db.operation.batch.size", len(cmds)),
+			),
+		)
+		start := time.Now()
 		err := next(ctx, cmds)
-
-		l := ZapLog
-		if traceID != "" {
-			l = l.With(zap.String(config.Server.Trace, traceID))
+		a.Metrics().observeRedis("pipeline", time.Since(start), err)
+		if shouldIgnoreRedisError(err) {
+			endSpan(span, nil)
+		} else {
+			endSpan(span, err)
 		}
 
 		if !shouldIgnoreRedisError(err) {
@@ -135,7 +153,7 @@ func (LogHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.Process
 	}
 }
 
-func (r *Redis) initRedis() {
+func (r *Redis) initRedis(a *App) error {
 	ctx := context.Background()
 	client := redis.NewClient(&redis.Options{
 		// 连接信息
@@ -162,11 +180,21 @@ func (r *Redis) initRedis() {
 		MinRetryBackoff: 8 * time.Millisecond,   // 每次计算重试间隔时间的下限，默认8毫秒，-1表示取消间隔
 		MaxRetryBackoff: 512 * time.Millisecond, // 每次计算重试间隔时间的上限，默认512毫秒，-1表示取消间隔
 	})
+	client.AddHook(LogHook{app: a})
+	a.Rdb = append(a.Rdb, client)
+	a.onClose(SubsystemRedis, func(ctx context.Context) error {
+		return client.Close()
+	})
+	a.onHealth(SubsystemRedis, fmt.Sprintf("%s[%d]", SubsystemRedis, len(a.Rdb)-1), func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	})
 	if err := client.Ping(ctx).Err(); err != nil {
-		ZapLog.Fatal("redis连接失败", zap.Error(err))
-	} else {
-		client.AddHook(LogHook{})
-		Rdb = append(Rdb, client)
-		ZapLog.Info("redis连接成功")
+		if a.rdbDown == nil {
+			a.rdbDown = make(map[int]bool)
+		}
+		a.rdbDown[len(a.Rdb)-1] = true
+		return fmt.Errorf("%s: %w", r.Addr, err)
 	}
+	a.Log.Info("redis连接成功")
+	return nil
 }
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/knadh/koanf/providers/file"
+	"go.uber.org/zap"
+	"reflect"
+)
+
+/*
+内置处理的配置段，变更后自动应用到对应子系统
+
+	日志级别、限流器使用的redis和代理地址原地切换，客户端实例不变
+	本地缓存需要重建实例，变更后只触发回调，重启后生效
+*/
+const (
+	SectionLog       = "log"
+	SectionCache     = "cache"
+	SectionLimiter   = "other.limiter"
+	SectionProxyAddr = "other.proxy-addr"
+)
+
+/*
+OnConfigChange 注册默认实例的配置变更回调
+
+	service.OnConfigChange("other.limiter", func() {})
+	service.OnConfigChange("my-section", func() {})
+*/
+func OnConfigChange(section string, fn func()) {
+	std.OnConfigChange(section, fn)
+}
+
+// OnConfigChange 注册配置变更回调，section为配置的键路径，热更新后该段有变化时调用
+func (a *App) OnConfigChange(section string, fn func()) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+	if a.callbacks == nil {
+		a.callbacks = make(map[string][]func())
+	}
+	a.callbacks[section] = append(a.callbacks[section], fn)
+}
+
+// watch 监听所有配置文件，任一变更都会重新合并全部来源
+func (a *App) watch() error {
+	if a.loader == nil || len(a.loader.files()) == 0 {
+		return errors.New("没有可监听的配置文件")
+	}
+	for _, path := range a.loader.files() {
+		fp := file.Provider(path)
+		err := fp.Watch(func(event interface{}, err error) {
+			if err != nil {
+				a.Log.Error("配置文件监听失败", zap.String("path", path), zap.Error(err))
+				return
+			}
+			a.Reload()
+		})
+		if err != nil {
+			return err
+		}
+		a.onClose(SubsystemConfig, func(ctx context.Context) error {
+			return fp.Unwatch()
+		})
+	}
+	return nil
+}
+
+// Reload 重新加载配置，应用内置配置段并触发变更回调
+func (a *App) Reload() {
+	a.reloadMu.Lock()
+	if a.loader == nil {
+		a.reloadMu.Unlock()
+		return
+	}
+	old := a.loader.k
+	// 加载到新的配置，校验失败时不影响正在使用的配置
+	conf, err := a.loader.load()
+	if err != nil {
+		a.reloadMu.Unlock()
+		a.Log.Error("配置热更新失败", zap.Error(err))
+		return
+	}
+	a.config.Store(conf)
+	changed := func(section string) bool {
+		return !reflect.DeepEqual(old.Get(section), a.loader.k.Get(section))
+	}
+	if changed(SectionLog) && conf.Log != nil {
+		if err := a.SetLogLevel(conf.Log.Level); err != nil {
+			a.Log.Error("日志级别更新失败", zap.Error(err))
+		}
+	}
+	if changed(SectionCache) {
+		a.Log.Warn("本地缓存配置已变更，重启后生效")
+	}
+	if changed(SectionLimiter) && conf.Other != nil && conf.Other.Limiter > -1 {
+		if err := a.switchLimit(conf.Other.Limiter); err != nil {
+			a.Log.Error("限流器更新失败", zap.Error(err))
+		}
+	}
+	if changed(SectionProxyAddr) && conf.Other != nil {
+		a.proxy.Store(&conf.Other.ProxyAddr)
+		if a.Http != nil {
+			// 已建立的连接仍走之前的代理，关闭后按新地址重新建立
+			a.Http.CloseIdleConnections()
+		}
+	}
+	var fns []func()
+	for section, v := range a.callbacks {
+		if changed(section) {
+			fns = append(fns, v...)
+		}
+	}
+	a.reloadMu.Unlock()
+	a.Log.Info("配置热更新完成")
+	// 释放锁后再回调，回调内可以再次注册
+	for _, fn := range fns {
+		fn()
+	}
+}
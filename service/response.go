@@ -0,0 +1,278 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ResponseFormat 返回结构的字段名，字段名为空时不输出该字段，链路id的字段名为server.trace
+type ResponseFormat struct {
+	Success     string
+	Code        string
+	Msg         string
+	Data        string
+	Page        string
+	SuccessCode int    // 成功时的code
+	Locale      string // 请求未指定Accept-Language或没有对应翻译时使用的语言
+}
+
+// DefaultResponseFormat 默认的返回结构 {"success":true,"code":0,"trace_id":"","data":{}}
+var DefaultResponseFormat = ResponseFormat{
+	Success: "success",
+	Code:    "code",
+	Msg:     "msg",
+	Data:    "data",
+	Page:    "page",
+	Locale:  "zh",
+}
+
+// SetResponseFormat 设置默认实例的返回结构
+func SetResponseFormat(format ResponseFormat) {
+	std.SetResponseFormat(format)
+}
+
+// SetResponseFormat 设置返回结构
+func (a *App) SetResponseFormat(format ResponseFormat) {
+	a.format = &format
+}
+
+// responseFormat 实例的返回结构，未设置时使用默认值
+func (a *App) responseFormat() *ResponseFormat {
+	if a.format != nil {
+		return a.format
+	}
+	return &DefaultResponseFormat
+}
+
+// Page 分页信息
+type Page struct {
+	Page  int   `json:"page"`
+	Size  int   `json:"size"`
+	Total int64 `json:"total"`
+}
+
+// Response 统一返回结构，序列化时按 ResponseFormat 输出字段
+type Response[T any] struct {
+	Success bool
+	Code    int
+	Msg     any
+	Data    T
+	Page    *Page
+	Trace   string
+
+	format   *ResponseFormat
+	traceKey string
+}
+
+// MarshalJSON 按字段名配置输出
+func (r Response[T]) MarshalJSON() ([]byte, error) {
+	f := r.format
+	if f == nil {
+		f = &DefaultResponseFormat
+	}
+	m := make(map[string]any, 6)
+	put := func(key string, value any) {
+		if key != "" {
+			m[key] = value
+		}
+	}
+	put(f.Success, r.Success)
+	put(f.Code, r.Code)
+	put(r.traceKey, r.Trace)
+	if r.Success {
+		put(f.Data, r.Data)
+		if r.Page != nil {
+			put(f.Page, r.Page)
+		}
+	} else {
+		put(f.Msg, r.Msg)
+	}
+	return json.Marshal(m)
+}
+
+/*
+Respond 输出返回结构，失败时中止后续处理
+
+	service.Respond(t, http.StatusOK, service.Response[User]{Success: true, Data: user})
+*/
+func Respond[T any](t *GinTracer, status int, r Response[T]) {
+	r.format = t.app.responseFormat()
+	r.traceKey = t.app.trace()
+	if r.Trace == "" {
+		r.Trace = t.Tid
+	}
+	if r.Success {
+		t.Ctx.JSON(status, r)
+		return
+	}
+	t.Ctx.AbortWithStatusJSON(status, r)
+}
+
+// Success 成功返回
+func (t *GinTracer) Success(data any) {
+	Respond(t, http.StatusOK, Response[any]{
+		Success: true,
+		Code:    t.app.responseFormat().SuccessCode,
+		Data:    data,
+	})
+}
+
+// SuccessPage 成功返回并附带分页信息
+func (t *GinTracer) SuccessPage(data any, page Page) {
+	Respond(t, http.StatusOK, Response[any]{
+		Success: true,
+		Code:    t.app.responseFormat().SuccessCode,
+		Data:    data,
+		Page:    &page,
+	})
+}
+
+/*
+Fail 失败返回，按错误码填充http状态码、code和当前语言的msg
+
+	未注册的错误按 ErrInternal 返回，原始错误只记录日志
+	t.Fail(ErrUserNotFound)
+	t.Fail(ErrUserNotFound.Wrap(err))
+*/
+func (t *GinTracer) Fail(err error) {
+	var e *ErrorCode
+	if !errors.As(err, &e) {
+		e = ErrInternal
+	}
+	if err != nil && err != error(e) {
+		t.Log.Error("请求失败", zap.Int("code", e.Code), zap.Error(err))
+	}
+	Respond(t, e.Status, Response[any]{
+		Code: e.Code,
+		Msg:  e.Message(t.locale()),
+	})
+}
+
+// locale 请求的Accept-Language，最后回退到默认语言
+func (t *GinTracer) locale() string {
+	if lang := t.Ctx.GetHeader("Accept-Language"); lang != "" {
+		return lang + "," + t.app.responseFormat().Locale
+	}
+	return t.app.responseFormat().Locale
+}
+
+// ErrorCode 注册的业务错误码
+type ErrorCode struct {
+	Code   int
+	Status int
+	Msgs   map[string]string // 语言 -> 提示信息
+}
+
+var (
+	errorCodes   = map[int]*ErrorCode{}
+	errorCodesMu sync.RWMutex
+	// builtinCodes 可以被覆盖一次的内置错误码
+	builtinCodes = map[int]bool{}
+)
+
+/*
+RegisterError 注册错误码，code重复时panic，应在包初始化时调用
+
+	var ErrUserNotFound = service.RegisterError(10404, http.StatusNotFound, map[string]string{
+		"zh": "用户不存在",
+		"en": "user not found",
+	})
+
+	内置的400、429、500可以注册一次以覆盖状态码和提示信息，返回的即是 ErrBadRequest 等内置错误码，
+	Handle 的参数错误、RateLimit 的限流、Fail 的未注册错误分别按覆盖后的400、429、500返回
+	var ErrParam = service.RegisterError(400, http.StatusOK, map[string]string{"zh": "参数错误"})
+*/
+func RegisterError(code, status int, msgs map[string]string) *ErrorCode {
+	errorCodesMu.Lock()
+	defer errorCodesMu.Unlock()
+	if e, ok := errorCodes[code]; ok {
+		if !builtinCodes[code] {
+			panic(fmt.Sprintf("错误码%d重复注册", code))
+		}
+		// 原地修改，框架内部使用的内置错误码随之生效
+		delete(builtinCodes, code)
+		e.Status = status
+		e.Msgs = msgs
+		return e
+	}
+	e := &ErrorCode{Code: code, Status: status, Msgs: msgs}
+	errorCodes[code] = e
+	return e
+}
+
+// registerBuiltin 注册内置错误码，调用方可以覆盖
+func registerBuiltin(code int, msgs map[string]string) *ErrorCode {
+	e := RegisterError(code, code, msgs)
+	builtinCodes[code] = true
+	return e
+}
+
+// LookupError 按code查找错误码
+func LookupError(code int) (*ErrorCode, bool) {
+	errorCodesMu.RLock()
+	defer errorCodesMu.RUnlock()
+	e, ok := errorCodes[code]
+	return e, ok
+}
+
+// 内置错误码，占用400、429、500，可以通过 RegisterError 覆盖
+var (
+	ErrInternal        = registerBuiltin(http.StatusInternalServerError, map[string]string{"zh": "服务异常", "en": "internal server error"})
+	ErrBadRequest      = registerBuiltin(http.StatusBadRequest, map[string]string{"zh": "参数异常", "en": "bad request"})
+	ErrTooManyRequests = registerBuiltin(http.StatusTooManyRequests, map[string]string{"zh": "请求过于频繁", "en": "too many requests"})
+)
+
+// Error 默认语言的提示信息
+func (e *ErrorCode) Error() string {
+	return e.Message(DefaultResponseFormat.Locale)
+}
+
+/*
+Message 按语言获取提示信息
+
+	支持Accept-Language格式，如 en-US,en;q=0.9,zh;q=0.8，依次尝试完整标签和主标签
+	都没有时返回语言标签排序后第一条翻译，保证每次结果一致
+*/
+func (e *ErrorCode) Message(lang string) string {
+	for _, v := range strings.Split(lang, ",") {
+		tag, _, _ := strings.Cut(strings.TrimSpace(v), ";")
+		if msg, ok := e.Msgs[tag]; ok {
+			return msg
+		}
+		primary, _, _ := strings.Cut(tag, "-")
+		if msg, ok := e.Msgs[primary]; ok {
+			return msg
+		}
+	}
+	if len(e.Msgs) > 0 {
+		return e.Msgs[slices.Min(slices.Collect(maps.Keys(e.Msgs)))]
+	}
+	return http.StatusText(e.Status)
+}
+
+// Wrap 附带原始错误，返回时仍按错误码输出，原始错误记录日志
+func (e *ErrorCode) Wrap(err error) error {
+	if err == nil {
+		return e
+	}
+	return &codeError{code: e, err: err}
+}
+
+type codeError struct {
+	code *ErrorCode
+	err  error
+}
+
+func (e *codeError) Error() string {
+	return e.code.Error() + ": " + e.err.Error()
+}
+
+func (e *codeError) Unwrap() []error {
+	return []error{e.code, e.err}
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+	"io"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrCircuitOpen 域名处于熔断状态，请求未发出
+var ErrCircuitOpen = errors.New("熔断中，请求未发出")
+
+/*
+Retry FastResponse 的重试策略
+
+	默认只重试幂等方法（GET、HEAD、OPTIONS、TRACE、PUT、DELETE）或带Idempotency-Key请求头的请求
+	&service.FastReqArg{Url: url, Method: "GET", Retry: &service.Retry{Attempts: 3}}
+*/
+type Retry struct {
+	Attempts      int                  // 总尝试次数，包括第一次，<=1时不重试
+	Backoff       time.Duration        // 第一次重试前的等待，之后每次翻倍，默认100ms
+	MaxBackoff    time.Duration        // 等待上限，也是Retry-After的上限，默认5s
+	Statuses      []int                // 需要重试的状态码，默认429、502、503、504
+	RetryIf       func(err error) bool // 需要重试的错误，默认超时、连接被拒绝或断开
+	NonIdempotent bool                 // 非幂等方法也重试
+}
+
+// withDefaults 填充未设置的字段
+func (r Retry) withDefaults() Retry {
+	if r.Backoff <= 0 {
+		r.Backoff = 100 * time.Millisecond
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = 5 * time.Second
+	}
+	if r.Statuses == nil {
+		r.Statuses = []int{
+			fasthttp.StatusTooManyRequests,
+			fasthttp.StatusBadGateway,
+			fasthttp.StatusServiceUnavailable,
+			fasthttp.StatusGatewayTimeout,
+		}
+	}
+	if r.RetryIf == nil {
+		r.RetryIf = retryableError
+	}
+	return r
+}
+
+// allowed 请求是否可以重试
+func (r Retry) allowed(req *fasthttp.Request) bool {
+	if r.Attempts <= 1 {
+		return false
+	}
+	if r.NonIdempotent || len(req.Header.Peek("Idempotency-Key")) > 0 {
+		return true
+	}
+	switch string(req.Header.Method()) {
+	case fasthttp.MethodGet, fasthttp.MethodHead, fasthttp.MethodOptions,
+		fasthttp.MethodTrace, fasthttp.MethodPut, fasthttp.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryable 本次结果是否需要重试
+func (r Retry) retryable(status int, err error) bool {
+	if err != nil {
+		return r.RetryIf(err)
+	}
+	for _, v := range r.Statuses {
+		if v == status {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+wait 第attempt次失败后的等待时间
+
+	指数退避加随机抖动，取[d/2, d]，响应带Retry-After秒数时优先使用
+*/
+func (r Retry) wait(attempt int, resp *fasthttp.Response) time.Duration {
+	if v := resp.Header.Peek("Retry-After"); len(v) > 0 {
+		if sec, err := strconv.Atoi(string(v)); err == nil && sec >= 0 {
+			return min(time.Duration(sec)*time.Second, r.MaxBackoff)
+		}
+	}
+	d := r.Backoff << (attempt - 1)
+	if attempt > 32 || d <= 0 || d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryableError 超时和连接异常可以重试
+func retryableError(err error) bool {
+	if errors.Is(err, fasthttp.ErrTimeout) ||
+		errors.Is(err, fasthttp.ErrDialTimeout) ||
+		errors.Is(err, fasthttp.ErrConnectionClosed) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+/*
+Breaker 按域名熔断，状态保存在实例中，同一域名应使用相同的配置
+
+	连续失败（请求错误或5xx）达到failures次后熔断，cooldown后进入半开
+	半开时放行probes个探测请求，成功则恢复，失败则重新熔断
+*/
+type Breaker struct {
+	Failures int           // 默认5
+	Cooldown time.Duration // 默认30s
+	Probes   int           // 默认1
+}
+
+// withDefaults 填充未设置的字段
+func (b Breaker) withDefaults() Breaker {
+	if b.Failures <= 0 {
+		b.Failures = 5
+	}
+	if b.Cooldown <= 0 {
+		b.Cooldown = 30 * time.Second
+	}
+	if b.Probes <= 0 {
+		b.Probes = 1
+	}
+	return b
+}
+
+// breakerState 熔断状态，数值即breaker_state指标的值
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// breaker 单个域名的熔断器
+type breaker struct {
+	host string
+	conf Breaker
+	app  *App
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+	probes   int
+}
+
+// breaker 获取域名的熔断器，不存在时按conf创建
+func (a *App) breaker(host string, conf Breaker) *breaker {
+	a.breakerMu.Lock()
+	defer a.breakerMu.Unlock()
+	if b, ok := a.breakers[host]; ok {
+		return b
+	}
+	if a.breakers == nil {
+		a.breakers = make(map[string]*breaker)
+	}
+	b := &breaker{host: host, conf: conf.withDefaults(), app: a}
+	a.breakers[host] = b
+	a.Metrics().breakerState.WithLabelValues(host).Set(float64(breakerClosed))
+	return b
+}
+
+// allow 是否放行，熔断时间已过则进入半开
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.conf.Cooldown {
+			return false
+		}
+		b.setState(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= b.conf.Probes {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+// done 记录放行请求的结果
+func (b *breaker) done(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		if b.probes > 0 {
+			b.probes--
+		}
+		if failed {
+			b.open()
+		} else {
+			b.failures = 0
+			b.setState(breakerClosed)
+		}
+		return
+	}
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerClosed && b.failures >= b.conf.Failures {
+		b.open()
+	}
+}
+
+func (b *breaker) open() {
+	b.openedAt = time.Now()
+	b.setState(breakerOpen)
+}
+
+// setState 切换状态，记录日志和指标
+func (b *breaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+	b.app.Log.Warn("FastResponse熔断状态变化",
+		zap.String("host", b.host),
+		zap.String("from", b.state.String()),
+		zap.String("to", state.String()),
+		zap.Int("failures", b.failures),
+	)
+	b.state = state
+	b.probes = 0
+	b.app.Metrics().breakerState.WithLabelValues(b.host).Set(float64(state))
+}
+
+/*
+doFast 按reqArg的重试和熔断策略发起请求，返回尝试次数
+
+	每次尝试都记录耗时指标，重试等待期间ctx取消时返回ctx的错误
+*/
+func (a *App) doFast(ctx context.Context, client *fasthttp.Client, req *fasthttp.Request, resp *fasthttp.Response,
+	reqArg *FastReqArg, host string, log *zap.Logger) (int, error) {
+	m := a.Metrics()
+	var b *breaker
+	if reqArg.Breaker != nil {
+		b = a.breaker(host, *reqArg.Breaker)
+	}
+	var retry Retry
+	if reqArg.Retry != nil && reqArg.Retry.allowed(req) {
+		retry = reqArg.Retry.withDefaults()
+	}
+	for attempt := 1; ; attempt++ {
+		if b != nil && !b.allow() {
+			m.breakerReject.WithLabelValues(host).Inc()
+			return attempt - 1, ErrCircuitOpen
+		}
+		start := time.Now()
+		err := client.Do(req, resp)
+		m.observeClient(host, resp.StatusCode(), time.Since(start), err)
+		if b != nil {
+			b.done(err != nil || resp.StatusCode() >= fasthttp.StatusInternalServerError)
+		}
+		if attempt >= retry.Attempts || !retry.retryable(resp.StatusCode(), err) {
+			return attempt, err
+		}
+		wait := retry.wait(attempt, resp)
+		m.clientRetries.WithLabelValues(host).Inc()
+		log.Warn("FastResponse重试",
+			zap.String("host", host),
+			zap.Int("attempt", attempt),
+			zap.Int("status", resp.StatusCode()),
+			zap.Duration("wait", wait),
+			zap.Error(err),
+		)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, ctx.Err()
+		case <-timer.C:
+		}
+		resp.Reset()
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+	"go.uber.org/zap"
+)
+
+func newTestBreaker(t *testing.T, conf Breaker) *breaker {
+	t.Helper()
+	a, err := NewApp(testConfig(t, &Config{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a.breaker("example.com", conf)
+}
+
+// expireBreaker 让熔断时间立即过期
+func expireBreaker(b *breaker) {
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-b.conf.Cooldown)
+	b.mu.Unlock()
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b := newTestBreaker(t, Breaker{Failures: 3, Cooldown: time.Hour})
+	for range 2 {
+		b.done(true)
+	}
+	// 成功清零连续失败次数
+	b.done(false)
+	for range 2 {
+		b.done(true)
+	}
+	if b.state != breakerClosed || !b.allow() {
+		t.Fatalf("未达到连续失败次数时应放行, state = %v", b.state)
+	}
+	b.done(true)
+	if b.state != breakerOpen || b.allow() {
+		t.Fatalf("连续失败后应熔断, state = %v", b.state)
+	}
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	b := newTestBreaker(t, Breaker{Failures: 1, Cooldown: time.Hour, Probes: 2})
+	b.done(true)
+	expireBreaker(b)
+	if !b.allow() || b.state != breakerHalfOpen {
+		t.Fatalf("熔断时间过后应进入半开, state = %v", b.state)
+	}
+	if !b.allow() || b.allow() {
+		t.Fatal("半开时只放行probes个请求")
+	}
+
+	// 探测失败重新熔断并重新计时
+	b.done(true)
+	if b.state != breakerOpen || b.allow() {
+		t.Fatalf("探测失败应重新熔断, state = %v", b.state)
+	}
+
+	expireBreaker(b)
+	if !b.allow() {
+		t.Fatal("再次过期后应放行探测")
+	}
+	b.done(false)
+	if b.state != breakerClosed || b.failures != 0 {
+		t.Fatalf("探测成功应恢复, state = %v failures = %d", b.state, b.failures)
+	}
+	if !b.allow() || !b.allow() || !b.allow() {
+		t.Fatal("恢复后不再限制请求数")
+	}
+}
+
+// serveFast 在内存中启动fasthttp服务
+func serveFast(t *testing.T, handler fasthttp.RequestHandler) *fasthttp.Client {
+	t.Helper()
+	ln := fasthttputil.NewInmemoryListener()
+	go func() { _ = fasthttp.Serve(ln, handler) }()
+	t.Cleanup(func() { _ = ln.Close() })
+	return &fasthttp.Client{Dial: func(addr string) (net.Conn, error) { return ln.Dial() }}
+}
+
+func TestDoFastRetryAndBreaker(t *testing.T) {
+	var calls atomic.Int32
+	client := serveFast(t, func(ctx *fasthttp.RequestCtx) {
+		if calls.Add(1) < 3 {
+			ctx.SetStatusCode(fasthttp.StatusServiceUnavailable)
+			return
+		}
+		ctx.SetStatusCode(fasthttp.StatusOK)
+	})
+	a, err := NewApp(testConfig(t, &Config{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method string, arg *FastReqArg) (int, int, error) {
+		req, resp := fasthttp.AcquireRequest(), fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseRequest(req)
+		defer fasthttp.ReleaseResponse(resp)
+		req.SetRequestURI("http://example.com/")
+		req.Header.SetMethod(method)
+		n, err := a.doFast(context.Background(), client, req, resp, arg, "example.com", zap.NewNop())
+		return n, resp.StatusCode(), err
+	}
+
+	retry := &Retry{Attempts: 3, Backoff: time.Millisecond}
+	if n, status, err := do(fasthttp.MethodGet, &FastReqArg{Retry: retry}); err != nil || n != 3 || status != fasthttp.StatusOK {
+		t.Fatalf("attempts = %d status = %d err = %v", n, status, err)
+	}
+
+	calls.Store(0)
+	if n, status, _ := do(fasthttp.MethodPost, &FastReqArg{Retry: retry}); n != 1 || status != fasthttp.StatusServiceUnavailable {
+		t.Fatalf("非幂等方法不应重试, attempts = %d status = %d", n, status)
+	}
+
+	// 两次5xx达到熔断阈值，第三次尝试不再发出
+	calls.Store(0)
+	n, _, err := do(fasthttp.MethodGet, &FastReqArg{Retry: retry, Breaker: &Breaker{Failures: 2, Cooldown: time.Hour}})
+	if err != ErrCircuitOpen || n != 2 || calls.Load() != 2 {
+		t.Fatalf("attempts = %d calls = %d err = %v", n, calls.Load(), err)
+	}
+}
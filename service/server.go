@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// GinServer 非阻塞启动的http服务，可同时监听多个地址
+type GinServer struct {
+	Engine *gin.Engine
+	Server *http.Server
+
+	app       *App
+	mu        sync.Mutex
+	listeners []net.Listener
+	errChan   chan error
+	tls       *tls.Config
+}
+
+// StartGin 在默认实例上非阻塞启动Gin
+func StartGin(router func(r *gin.Engine), skipPaths []string, opts ...GinOption) (*GinServer, error) {
+	var s *GinServer
+	err := withStd(func(a *App) error {
+		var err error
+		s, err = a.StartGin(router, skipPaths, opts...)
+		return err
+	})
+	return s, err
+}
+
+/*
+StartGin 非阻塞启动Gin，返回服务句柄
+
+	默认监听server.host:server.port，WithAddrs的端口为0时由系统分配
+	配置了server.cert-file时所有监听都使用TLS，并支持HTTP/2
+	s, err := app.StartGin(router, nil, service.WithAddrs("127.0.0.1:0"))
+	addr := s.Addr().String()
+	defer s.Shutdown(ctx)
+*/
+func (a *App) StartGin(router func(r *gin.Engine), skipPaths []string, opts ...GinOption) (*GinServer, error) {
+	server := a.Config().Server
+	o := newGinOptions(server, opts)
+	r, err := a.newGin(router, skipPaths, o)
+	if err != nil {
+		return nil, err
+	}
+	addrs := o.addrs
+	if len(addrs) == 0 {
+		if server.Port < 1 {
+			return nil, newInitError(SubsystemGin, errors.New("错误的端口号"))
+		}
+		addrs = []string{server.Host + ":" + strconv.Itoa(server.Port)}
+	}
+	s := &GinServer{
+		Engine: r,
+		Server: &http.Server{
+			Addr:    addrs[0],
+			Handler: r.Handler(),
+		},
+		app:     a,
+		errChan: make(chan error, 1),
+	}
+	if server.CertFile != "" {
+		reloader, err := a.newTlsReloader(server)
+		if err != nil {
+			return nil, newInitError(SubsystemGin, err)
+		}
+		s.tls = reloader.config()
+		s.Server.TLSConfig = s.tls
+	} else if server.H2c {
+		// 明文HTTP/2，供内网或前置代理已终止TLS的场景
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		s.Server.Protocols = protocols
+	}
+	for _, addr := range addrs {
+		if err = s.Listen(addr); err != nil {
+			_ = s.Server.Close()
+			return nil, newInitError(SubsystemGin, err)
+		}
+	}
+	a.onClose(SubsystemGin, s.Shutdown)
+	return s, nil
+}
+
+// Listen 增加一个监听地址
+func (s *GinServer) Listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.Serve(ln)
+	return nil
+}
+
+// Serve 在已有的监听上提供服务，启用TLS时会包装为TLS监听
+func (s *GinServer) Serve(ln net.Listener) {
+	if s.tls != nil {
+		ln = tls.NewListener(ln, s.tls)
+	}
+	s.mu.Lock()
+	s.listeners = append(s.listeners, ln)
+	s.mu.Unlock()
+	if s.app.Log != nil {
+		s.app.Log.Info("http服务启动", zap.String("addr", ln.Addr().String()))
+	}
+	go func() {
+		err := s.Server.Serve(ln)
+		if err == nil || errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		// 只保留第一个错误
+		select {
+		case s.errChan <- err:
+		default:
+		}
+	}()
+}
+
+// Addr 第一个监听的实际地址
+func (s *GinServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Addr()
+}
+
+// Addrs 所有监听的实际地址
+func (s *GinServer) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, len(s.listeners))
+	for i, ln := range s.listeners {
+		addrs[i] = ln.Addr()
+	}
+	return addrs
+}
+
+// Err 监听异常退出时收到错误，正常关闭不会收到
+func (s *GinServer) Err() <-chan error {
+	return s.errChan
+}
+
+// Shutdown 停止接收新连接并等待处理中的请求结束
+func (s *GinServer) Shutdown(ctx context.Context) error {
+	return s.Server.Shutdown(ctx)
+}
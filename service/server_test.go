@@ -0,0 +1,15 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStartGinWithoutServerConfig(t *testing.T) {
+	a := newApp(&Config{})
+	_, err := a.StartGin(pingRouter, nil)
+	var initErr *InitError
+	if !errors.As(err, &initErr) || initErr.Subsystem != SubsystemGin {
+		t.Fatalf("缺少server配置应返回初始化错误: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/bytedance/sonic"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-contrib/requestid"
@@ -19,17 +20,22 @@ import (
 	"github.com/sundaqiang/sdq-go/common"
 	"github.com/valyala/fasthttp"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
 	"gorm.io/gorm"
-	"strconv"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 	_ "time/tzdata"
 )
 
 var (
-	config Config
 	//json           = sonic.ConfigFastest
 	json = sonic.Config{
 		NoQuoteTextMarshaler:    true,
@@ -52,76 +58,143 @@ var (
 )
 
 type GeneralTracer struct {
-	Cache *ecache.Cache
-	Ctx   *context.Context
-	Cron  gocron.Scheduler
-	Db    *gorm.DB
-	Http  *fasthttp.Client
-	Log   *zap.Logger
-	Rdb   []*redis.Client
-	Sony  *sonyflake.Sonyflake
-	Tid   string
+	Cache   *ecache.Cache
+	Ctx     *context.Context
+	Cron    gocron.Scheduler
+	Db      *gorm.DB
+	Http    *fasthttp.Client
+	Ipdb    *ipdb.City
+	Limiter *RedisRate
+	Log     *zap.Logger
+	Mdb     *mongo.Client
+	Rdb     []*redis.Client
+	Sony    *sonyflake.Sonyflake
+	Tid     string
+	SpanId  string // 子tracer的span id，根tracer为空
+	app     *App
+	cancel  context.CancelFunc
+	span    trace.Span
 }
 
 // GetGeneralTracer 获取上下文实例
 func GetGeneralTracer() *GeneralTracer {
+	return std.GetGeneralTracer()
+}
+
+// GetGeneralTracer 获取上下文实例，用于定时任务和后台协程，用完调用 End
+func (a *App) GetGeneralTracer() *GeneralTracer {
 	tid := uuid.New().String()
-	c := context.WithValue(context.Background(), config.Server.Trace, tid)
+	c, cancel := context.WithCancel(context.WithValue(context.Background(), a.trace(), tid))
+	return a.newGeneralTracer(c, cancel, tid, "", a.Log.With(zap.String(a.trace(), tid)))
+}
+
+// newGeneralTracer 以c为上下文创建 GeneralTracer
+func (a *App) newGeneralTracer(c context.Context, cancel context.CancelFunc, tid, spanId string, log *zap.Logger) *GeneralTracer {
+	var db *gorm.DB
+	if a.Db != nil {
+		db = a.Db.WithContext(c)
+	}
 	return &GeneralTracer{
-		Cache: LRUCache,
-		Cron:  GoCron,
-		Ctx:   &c,
-		Db:    Db.WithContext(c),
-		Http:  FastHttpClient,
-		Log:   ZapLog.With(zap.String(config.Server.Trace, tid)),
-		Rdb:   Rdb,
-		Sony:  SonyFlake,
-		Tid:   tid,
+		Cache:   a.Cache,
+		Cron:    a.Cron,
+		Ctx:     &c,
+		Db:      db,
+		Http:    a.Http,
+		Ipdb:    a.Ipdb,
+		Limiter: a.Limiter,
+		Log:     log,
+		Mdb:     a.Mdb,
+		Rdb:     a.Rdb,
+		Sony:    a.Sony,
+		Tid:     tid,
+		SpanId:  spanId,
+		app:     a,
+		cancel:  cancel,
 	}
 }
 
 // InitGORM 初始化GORM
-func InitGORM(info *Gorm) {
-	if info != nil {
-		initDB(info)
+func InitGORM(info *Gorm) error {
+	return withStd(func(a *App) error {
+		return a.InitGORM(info)
+	})
+}
+
+// InitGORM 初始化GORM
+func (a *App) InitGORM(info *Gorm) error {
+	if info == nil {
+		return nil
 	}
+	return newInitError(SubsystemGorm, a.initDB(info))
 }
 
 // InitRdb 初始化Redis
-func InitRdb(info *[]Redis) {
-	if info != nil {
-		for _, v := range *info {
-			if v.Network != "" && v.Addr != "" {
-				v.initRedis()
+func InitRdb(info *[]Redis) error {
+	return withStd(func(a *App) error {
+		return a.InitRdb(info)
+	})
+}
+
+// InitRdb 初始化Redis，连接失败的实例仍会占位，保证索引与配置一致
+func (a *App) InitRdb(info *[]Redis) error {
+	if info == nil {
+		return nil
+	}
+	var errs []error
+	for _, v := range *info {
+		if v.Network != "" && v.Addr != "" {
+			if err := v.initRedis(a); err != nil {
+				errs = append(errs, err)
 			}
 		}
 	}
+	return newInitError(SubsystemRedis, errors.Join(errs...))
+}
+
+// InitGoCron 初始化GoCron
+func InitGoCron(cronAsync bool) error {
+	return withStd(func(a *App) error {
+		return a.InitGoCron(cronAsync)
+	})
 }
 
 // InitGoCron 初始化GoCron
-func InitGoCron(cronAsync bool) {
+func (a *App) InitGoCron(cronAsync bool) error {
 	var err error
 	t, timeLocationErr := time.LoadLocation("Asia/Shanghai")
 	if timeLocationErr != nil {
 		t = time.FixedZone("CST", 8*3600)
 	}
-	GoCron, err = gocron.NewScheduler(
+	a.Cron, err = gocron.NewScheduler(
 		gocron.WithLocation(t),
 		gocron.WithGlobalJobOptions(
 			gocron.WithSingletonMode(
 				gocron.LimitModeReschedule,
 			),
 		),
+		gocron.WithMonitor(cronMonitor{m: a.Metrics()}),
 	)
 	if err != nil {
-		ZapLog.Error("GoCron初始化失败",
-			zap.Error(err),
-		)
-		return
+		return newInitError(SubsystemCron, err)
 	}
 	if cronAsync {
-		GoCron.Start()
+		a.Cron.Start()
 	}
+	cron := a.Cron
+	a.onClose(SubsystemCron, func(ctx context.Context) error {
+		// 等待运行中的任务结束
+		return waitDone(ctx, cron.Shutdown)
+	})
+	a.onHealth(SubsystemCron, SubsystemCron, func(ctx context.Context) error {
+		// 调度器关闭后任务无法再取得下次运行时间
+		for _, job := range cron.Jobs() {
+			if _, err := job.NextRun(); err != nil {
+				return fmt.Errorf("%s: %w", job.Name(), err)
+			}
+		}
+		return nil
+	})
+	return nil
 }
 
 /*
@@ -129,68 +202,130 @@ InitGin 初始化Gin
 编译需要加tags
 -tags "sonic avx linux amd64"
 */
-func InitGin(router func(r *gin.Engine), skipPaths []string) {
-	if config.Server.Host != "" {
-		if config.Server.Host = common.MatchIp(config.Server.Host); config.Server.Host == "" {
-			ZapLog.Error("Gin初始化失败",
-				zap.Error(errors.New("错误的Ip地址")),
-			)
-			return
-		}
+func InitGin(router func(r *gin.Engine), skipPaths []string, opts ...GinOption) error {
+	return withStd(func(a *App) error {
+		return a.InitGin(router, skipPaths, opts...)
+	})
+}
+
+// InitGin 初始化Gin，阻塞到服务退出或收到SIGINT、SIGTERM
+func (a *App) InitGin(router func(r *gin.Engine), skipPaths []string, opts ...GinOption) error {
+	s, err := a.StartGin(router, skipPaths, opts...)
+	if err != nil {
+		return err
+	}
+	// 收到SIGINT或SIGTERM时关闭整个实例
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err = <-s.Err():
+		return newInitError(SubsystemGin, err)
+	case <-ctx.Done():
+		return a.shutdownWithTimeout()
 	}
-	if config.Server.Port < 1 {
-		ZapLog.Error("Gin初始化失败",
-			zap.Error(errors.New("错误的端口号")),
-		)
-		return
+}
+
+/*
+newGin 创建Gin并加载中间件和路由
+
+	中间件顺序：Recovery > requestid > 安全响应头 > 跨域 > 指标 > 链路追踪 > 请求体限制 > 日志 > 超时 > 压缩 > 自定义
+*/
+func (a *App) newGin(router func(r *gin.Engine), skipPaths []string, o *ginOptions) (*gin.Engine, error) {
+	server := a.Config().Server
+	if server == nil {
+		return nil, newInitError(SubsystemGin, errors.New("缺少server配置"))
 	}
-	if config.Server.Trans {
+	if server.Host != "" {
+		if server.Host = common.MatchIp(server.Host); server.Host == "" {
+			return nil, newInitError(SubsystemGin, errors.New("错误的Ip地址"))
+		}
+	}
+	if server.Trans {
 		if err := initValidator("zh"); err != nil {
-			ZapLog.Error("Gin初始化失败",
-				zap.Error(err),
-			)
-			return
+			return nil, newInitError(SubsystemGin, err)
 		}
 	}
 	r := gin.New()
+	// 让gin.Context的Deadline、Done和Value使用请求的context，超时、取消和span对 GinTracer 生效
+	r.ContextWithFallback = true
 	r.Use(gin.Recovery())
-	// 一个唯一id的中间件
+	// 让处理函数通过gin.Context找到所属实例
+	r.Use(func(c *gin.Context) {
+		c.Set(appContextKey, a)
+	})
+	// 一个唯一id的中间件，沿用上游传入的合法id
+	r.Use(traceHeaderMiddleware(common.KebabString(server.Trace)))
 	r.Use(requestid.New(
-		requestid.WithCustomHeaderStrKey(requestid.HeaderStrKey(common.KebabString(config.Server.Trace))),
+		requestid.WithCustomHeaderStrKey(requestid.HeaderStrKey(common.KebabString(server.Trace))),
 	))
+	mw := o.middleware
+	if err := validateMiddleware(&mw); err != nil {
+		return nil, newInitError(SubsystemGin, err)
+	}
+	// 错误响应和预检请求也要带上安全响应头和跨域头
+	if mw.Secure.Enable {
+		r.Use(secureMiddleware(mw.Secure))
+	}
+	if mw.Cors.Enable {
+		r.Use(corsMiddleware(mw.Cors, common.KebabString(server.Trace)))
+	}
+	// 存活与就绪检查，注册在日志中间件之前，避免探针刷日志
+	a.registerHealth(r, server)
+	// 接口文档
+	if server.Docs != "" {
+		a.registerDocs(r, server.Docs)
+	}
+	// prometheus指标
+	if server.Metrics != "" {
+		a.registerMetrics(r, server.Metrics)
+	}
 	// pprof
 	if gin.Mode() != gin.ReleaseMode {
 		pprof.Register(r, "dev/pprof")
+		// 查看脱敏后的生效配置
+		if server.DevConfig {
+			r.GET("dev/config", func(c *gin.Context) {
+				c.JSON(http.StatusOK, a.DumpConfig())
+			})
+		}
 	}
 
 	// 加载路由
 	if router == nil {
-		ZapLog.Error("Gin初始化失败",
-			zap.Error(errors.New("错误的路由函数")),
-		)
-		return
+		return nil, newInitError(SubsystemGin, errors.New("错误的路由函数"))
 	}
 
+	// 请求数和耗时，包含被限制和超时的请求
+	r.Use(metricsMiddleware(a.Metrics()))
+	// 链路追踪
+	if a.tracerProvider != nil {
+		r.Use(tracingMiddleware(a))
+	}
+	// 在日志读取请求体之前限制大小
+	if mw.BodyLimit > 0 {
+		r.Use(bodyLimitMiddleware(mw.BodyLimit))
+	}
 	// 将gin的日志改为zap
-	r.Use(GinZapWithConfig(ZapLog,
+	r.Use(GinZapWithAccessLog(a.Log,
 		&ginZap.Config{
 			UTC:        false,
 			TimeFormat: time.RFC3339,
 			SkipPaths:  skipPaths,
 		},
-		config.Server.Trace,
+		server.Trace,
+		mw.AccessLog,
 	))
-	r.Use(ginZap.RecoveryWithZap(ZapLog, true))
+	r.Use(ginZap.RecoveryWithZap(a.Log, true))
+	if mw.Timeout > 0 {
+		r.Use(timeoutMiddleware(mw.Timeout))
+	}
+	if mw.Gzip.Enable {
+		r.Use(gzipMiddleware(mw.Gzip))
+	}
+	r.Use(o.handlers...)
 
 	router(r)
-
-	err := r.Run(config.Server.Host + ":" + strconv.Itoa(config.Server.Port))
-	if err != nil {
-		ZapLog.Error("Gin初始化失败",
-			zap.Error(err),
-		)
-		return
-	}
+	return r, nil
 }
 
 /*
@@ -205,21 +340,31 @@ InitLogger 必须
 		Compress:   false,
 	}
 */
-func InitLogger(logger *lumberjack.Logger, callerSkip int) bool {
+func InitLogger(logger *lumberjack.Logger, callerSkip int) error {
+	return withStd(func(a *App) error {
+		return a.InitLogger(logger, callerSkip)
+	})
+}
+
+// InitLogger 初始化日志
+func (a *App) InitLogger(logger *lumberjack.Logger, callerSkip int) error {
 	if logger == nil {
-		return false
+		return newInitError(SubsystemLog, errors.New("缺少日志配置"))
 	}
+	// 最低级别可通过 SetLogLevel 动态调整
+	level := zap.NewAtomicLevelAt(zapcore.DebugLevel)
+	a.level = level
 	debugLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl == zapcore.DebugLevel
+		return lvl == zapcore.DebugLevel && level.Enabled(lvl)
 	})
 	infoLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl == zapcore.InfoLevel
+		return lvl == zapcore.InfoLevel && level.Enabled(lvl)
 	})
 	warnLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl == zapcore.WarnLevel
+		return lvl == zapcore.WarnLevel && level.Enabled(lvl)
 	})
 	errorLevel := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
-		return lvl >= zapcore.ErrorLevel
+		return lvl >= zapcore.ErrorLevel && level.Enabled(lvl)
 	})
 	var debugLogger, infoLogger, warnLogger, errorLogger *lumberjack.Logger
 	if logger.Filename != "" {
@@ -255,41 +400,86 @@ func InitLogger(logger *lumberjack.Logger, callerSkip int) bool {
 		zapcore.NewCore(encoder, zapcore.AddSync(warnWriter), warnLevel),
 		zapcore.NewCore(encoder, zapcore.AddSync(errorWriter), errorLevel),
 	)
-	ZapLog = zap.New(core, zap.AddCaller(), zap.AddCallerSkip(callerSkip))
+	a.Log = zap.New(core, zap.AddCaller(), zap.AddCallerSkip(callerSkip))
 	defer func(zapLog *zap.Logger) {
 		err := zapLog.Sync()
 		if err != nil {
 
 		}
-	}(ZapLog)
-	return true
+	}(a.Log)
+	zapLog := a.Log
+	a.onClose(SubsystemLog, func(ctx context.Context) error {
+		// 标准输出不支持Sync，忽略其错误
+		_ = zapLog.Sync()
+		var errs []error
+		for _, v := range []*lumberjack.Logger{debugLogger, infoLogger, warnLogger, errorLogger} {
+			if v != nil {
+				errs = append(errs, v.Close())
+			}
+		}
+		return errors.Join(errs...)
+	})
+	return nil
+}
+
+// SetLogLevel 设置默认实例的最低日志级别
+func SetLogLevel(level string) error {
+	return std.SetLogLevel(level)
+}
+
+// SetLogLevel 设置最低日志级别，level为空时不做修改
+func (a *App) SetLogLevel(level string) error {
+	if level == "" {
+		return nil
+	}
+	if a.level == (zap.AtomicLevel{}) {
+		return errors.New("日志未通过InitLogger初始化")
+	}
+	lvl, err := zapcore.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	a.level.SetLevel(lvl)
+	return nil
 }
 
 // InitFastHttp 可选，CheckNetwork 检查是否有网络必选
 func InitFastHttp(proxyAddr string) {
-	if proxyAddr == "" {
-		FastHttpClient = &fasthttp.Client{
-			MaxConnsPerHost: 10240,
-		}
-		return
-	}
-	FastHttpClient = &fasthttp.Client{
+	_ = withStd(func(a *App) error {
+		a.InitFastHttp(proxyAddr)
+		return nil
+	})
+}
+
+// InitFastHttp 初始化fasthttp客户端，代理地址可以通过热更新切换
+func (a *App) InitFastHttp(proxyAddr string) {
+	a.proxy.Store(&proxyAddr)
+	a.Http = &fasthttp.Client{
 		MaxConnsPerHost: 10240,
-		Dial:            fastHTTPDialer(proxyAddr),
+		DialTimeout: func(addr string, timeout time.Duration) (net.Conn, error) {
+			if proxy := a.proxy.Load(); proxy != nil && *proxy != "" {
+				return fastHTTPDialer(*proxy)(addr)
+			}
+			return fasthttp.DialTimeout(addr, timeout)
+		},
 	}
 }
 
 // InitSonyFlake 初始化雪花Id
-func InitSonyFlake(settings sonyflake.Settings) bool {
+func InitSonyFlake(settings sonyflake.Settings) error {
+	return withStd(func(a *App) error {
+		return a.InitSonyFlake(settings)
+	})
+}
+
+// InitSonyFlake 初始化雪花Id
+func (a *App) InitSonyFlake(settings sonyflake.Settings) error {
 	var err error
-	SonyFlake, err = sonyflake.New(settings)
+	a.Sony, err = sonyflake.New(settings)
 	if err != nil {
-		ZapLog.Error("雪花id初始化失败",
-			zap.Error(err),
-		)
-		return false
+		return newInitError(SubsystemSonyFlake, err)
 	}
-	return true
+	return nil
 }
 
 /*
@@ -302,12 +492,48 @@ InitLocalCache 本地缓存
 	size:redis缓存区尺寸
 */
 func InitLocalCache(bucketCnt, capPerBkt, capPerBkt2 uint16, rdb *redis.Client, size int, expiration time.Duration) {
+	_ = withStd(func(a *App) error {
+		a.InitLocalCache(bucketCnt, capPerBkt, capPerBkt2, rdb, size, expiration)
+		return nil
+	})
+}
+
+// InitLocalCache 初始化本地缓存
+func (a *App) InitLocalCache(bucketCnt, capPerBkt, capPerBkt2 uint16, rdb *redis.Client, size int, expiration time.Duration) {
 	if capPerBkt2 > 0 {
-		LRUCache = ecache.NewLRUCache(bucketCnt, capPerBkt, expiration).LRU2(capPerBkt2)
+		a.Cache = ecache.NewLRUCache(bucketCnt, capPerBkt, expiration).LRU2(capPerBkt2)
 	} else {
-		LRUCache = ecache.NewLRUCache(bucketCnt, capPerBkt, expiration)
+		a.Cache = ecache.NewLRUCache(bucketCnt, capPerBkt, expiration)
+	}
+	// 重建时先取消之前的订阅
+	if a.cacheCli != nil {
+		_ = a.cacheCli.Close()
+		a.cacheCli = nil
 	}
 	if rdb != nil {
-		dist.Init(Take(rdb, size))
+		distMu.Lock()
+		defer distMu.Unlock()
+		if distOwner != nil && distOwner != a {
+			a.Log.Warn("本地缓存的redis同步已由其他实例开启，当前实例不同步")
+			return
+		}
+		distOwner = a
+		cli := newGoRedisCli(rdb, size)
+		a.cacheCli = cli
+		dist.Init(cli)
+		a.onClose(SubsystemCache, func(ctx context.Context) error {
+			distMu.Lock()
+			if distOwner == a {
+				distOwner = nil
+			}
+			distMu.Unlock()
+			return cli.Close()
+		})
 	}
 }
+
+var (
+	// distOwner 开启本地缓存redis同步的实例，ecache的dist是进程级的，只能有一个
+	distOwner *App
+	distMu    sync.Mutex
+)
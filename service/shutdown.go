@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// closer 子系统的关闭函数
+type closer struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// onClose 登记关闭函数，Shutdown时按登记的逆序执行
+func (a *App) onClose(name string, fn func(ctx context.Context) error) {
+	a.closeMu.Lock()
+	defer a.closeMu.Unlock()
+	a.closers = append(a.closers, closer{name: name, fn: fn})
+}
+
+// Shutdown 关闭默认实例
+func Shutdown(ctx context.Context) error {
+	return std.Shutdown(ctx)
+}
+
+/*
+Shutdown 按初始化的逆序关闭各子系统
+
+	先停止http服务并等待处理中的请求，再停止定时任务，最后关闭数据库连接并刷新日志
+	重复调用只执行一次
+*/
+func (a *App) Shutdown(ctx context.Context) error {
+	a.shutdownOnce.Do(func() {
+		a.draining.Store(true)
+		a.closeMu.Lock()
+		closers := a.closers
+		a.closers = nil
+		a.closeMu.Unlock()
+		var errs []error
+		for i := len(closers) - 1; i >= 0; i-- {
+			c := closers[i]
+			if err := c.fn(ctx); err != nil {
+				if a.Log != nil && c.name != SubsystemLog {
+					a.Log.Error("关闭失败", zap.String("subsystem", c.name), zap.Error(err))
+				}
+				errs = append(errs, newCloseError(c.name, err))
+			}
+		}
+		a.shutdownErr = errors.Join(errs...)
+	})
+	return a.shutdownErr
+}
+
+// Wait 阻塞默认实例直到收到退出信号，然后关闭
+func Wait() error {
+	return std.Wait()
+}
+
+// Wait 阻塞直到收到SIGINT或SIGTERM，然后在超时时间内关闭
+func (a *App) Wait() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	return a.shutdownWithTimeout()
+}
+
+// shutdownWithTimeout 使用server.shutdown-timeout关闭，默认10秒
+func (a *App) shutdownWithTimeout() error {
+	timeout := 10 * time.Second
+	if server := a.Config().Server; server != nil && server.ShutdownTimeout > 0 {
+		timeout = time.Duration(server.ShutdownTimeout) * time.Second
+	}
+	if a.Log != nil {
+		a.Log.Info("收到退出信号，开始关闭", zap.Duration("timeout", timeout))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return a.Shutdown(ctx)
+}
+
+// waitDone 在ctx结束前等待fn完成，用于不支持ctx的关闭函数
+func waitDone(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
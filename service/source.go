@@ -0,0 +1,87 @@
+package service
+
+import (
+	"errors"
+	"flag"
+	"github.com/knadh/koanf/maps"
+	koanfJson "github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/parsers/toml"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/providers/file"
+	"github.com/knadh/koanf/v2"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigSource 配置来源，多个来源按顺序合并，后面的覆盖前面的
+type ConfigSource struct {
+	path   string
+	prefix string
+	flags  *flag.FlagSet
+}
+
+// FileSource 配置文件，按扩展名识别 .toml .yaml .yml .json
+func FileSource(path string) ConfigSource {
+	return ConfigSource{path: path}
+}
+
+// EnvSource 以prefix开头的环境变量，PREFIX_SERVER_PORT 对应 server.port，PREFIX_DATABASE_REDIS_1_ADDR 对应第2个redis的addr
+func EnvSource(prefix string) ConfigSource {
+	return ConfigSource{prefix: prefix}
+}
+
+// FlagSource 命令行参数，只取显式设置过的，参数名即键路径，如 -server.port=8080
+func FlagSource(fs *flag.FlagSet) ConfigSource {
+	return ConfigSource{flags: fs}
+}
+
+// load 将来源加载到k，conf与tag用于解析环境变量的键路径
+func (s ConfigSource) load(k *koanf.Koanf, conf any, tag string) error {
+	switch {
+	case s.path != "":
+		parser, err := fileParser(s.path)
+		if err != nil {
+			return err
+		}
+		return k.Load(file.Provider(s.path), parser)
+	case s.prefix != "":
+		return loadEnv(k, s.prefix, conf, tag)
+	case s.flags != nil:
+		return k.Load(flagProvider{fs: s.flags}, nil)
+	}
+	return nil
+}
+
+// fileParser 按扩展名选择解析器
+func fileParser(path string) (koanf.Parser, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		return toml.Parser(), nil
+	case ".yaml", ".yml":
+		return yaml.Parser(), nil
+	case ".json":
+		return koanfJson.Parser(), nil
+	}
+	return nil, errors.New("不支持的配置文件格式: " + path)
+}
+
+// flagProvider 标准库flag的koanf provider
+type flagProvider struct {
+	fs *flag.FlagSet
+}
+
+func (p flagProvider) ReadBytes() ([]byte, error) {
+	return nil, errors.New("flag provider不支持ReadBytes")
+}
+
+func (p flagProvider) Read() (map[string]interface{}, error) {
+	m := make(map[string]interface{})
+	p.fs.Visit(func(f *flag.Flag) {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			m[f.Name] = getter.Get()
+			return
+		}
+		m[f.Name] = f.Value.String()
+	})
+	return maps.Unflatten(m, "."), nil
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"github.com/knadh/koanf/providers/file"
+	"go.uber.org/zap"
+	"os"
+	"sync"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsReloader 持有当前的证书配置，证书文件变更后重新加载
+type tlsReloader struct {
+	certFile   string
+	keyFile    string
+	clientCa   string
+	minVersion uint16
+
+	mu   sync.RWMutex
+	conf *tls.Config
+}
+
+// newTlsReloader 加载证书并监听证书文件
+func (a *App) newTlsReloader(server *Server) (*tlsReloader, error) {
+	r := &tlsReloader{
+		certFile:   server.CertFile,
+		keyFile:    server.KeyFile,
+		clientCa:   server.ClientCa,
+		minVersion: tlsVersions[server.TlsMinVersion],
+	}
+	if r.minVersion == 0 {
+		r.minVersion = tls.VersionTLS12
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	for _, path := range []string{r.certFile, r.keyFile, r.clientCa} {
+		if path == "" {
+			continue
+		}
+		fp := file.Provider(path)
+		err := fp.Watch(func(event interface{}, err error) {
+			if err == nil {
+				err = r.load()
+			}
+			if err != nil {
+				// 加载失败时继续使用之前的证书
+				a.Log.Error("证书重新加载失败", zap.String("path", path), zap.Error(err))
+				return
+			}
+			a.Log.Info("证书已重新加载", zap.String("path", path))
+		})
+		if err != nil {
+			return nil, err
+		}
+		a.onClose(SubsystemGin, func(ctx context.Context) error {
+			return fp.Unwatch()
+		})
+	}
+	return r, nil
+}
+
+// load 读取证书和客户端CA
+func (r *tlsReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	conf := &tls.Config{
+		MinVersion:   r.minVersion,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if r.clientCa != "" {
+		pem, err := os.ReadFile(r.clientCa)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("无效的客户端CA证书: " + r.clientCa)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	r.mu.Lock()
+	r.conf = conf
+	r.mu.Unlock()
+	return nil
+}
+
+// config 监听使用的配置，每次握手取最新的证书
+func (r *tlsReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: r.minVersion,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.conf, nil
+		},
+	}
+}
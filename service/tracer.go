@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"math/rand/v2"
+	"time"
+)
+
+/*
+Tracer GinTracer 与 GeneralTracer 的公共能力，便于处理函数、定时任务和后台协程共用同一套逻辑
+
+	func syncUser(t service.Tracer, id int64) error {
+		child := t.ChildTimeout("sync-user", 5*time.Second)
+		defer child.End()
+		return child.Mdb.Database("app").Collection("user").FindOne(child.Context(), bson.M{"_id": id}).Err()
+	}
+*/
+type Tracer interface {
+	// Context 用于取消和超时的上下文，带链路id和span
+	Context() context.Context
+	// TraceId 链路id
+	TraceId() string
+	// Logger 带链路id的日志
+	Logger() *zap.Logger
+	// Mongo mongo客户端，未初始化时为nil
+	Mongo() *mongo.Client
+	// RateLimiter 限流器，未初始化时为nil
+	RateLimiter() *RedisRate
+	// FastResponse 发起请求，请求头带链路id
+	FastResponse(reqArg *FastReqArg, resArg *FastResArg) bool
+	// Child 创建子tracer，沿用链路id并生成span id，父级取消时一起取消，用完调用 End
+	Child(name string) *GeneralTracer
+	// ChildTimeout 创建带超时的子tracer
+	ChildTimeout(name string, timeout time.Duration) *GeneralTracer
+}
+
+var (
+	_ Tracer = (*GinTracer)(nil)
+	_ Tracer = (*GeneralTracer)(nil)
+)
+
+// Context 请求的上下文，请求结束或超时后取消
+func (t *GinTracer) Context() context.Context {
+	return t.Ctx
+}
+
+// TraceId 链路id
+func (t *GinTracer) TraceId() string {
+	return t.Tid
+}
+
+// Logger 带链路id的日志
+func (t *GinTracer) Logger() *zap.Logger {
+	return t.Log
+}
+
+// Mongo mongo客户端
+func (t *GinTracer) Mongo() *mongo.Client {
+	return t.Mdb
+}
+
+// RateLimiter 限流器
+func (t *GinTracer) RateLimiter() *RedisRate {
+	return t.Limiter
+}
+
+// Child 创建子tracer，请求结束后随之取消，不随请求结束的后台任务使用 GetGeneralTracer
+func (t *GinTracer) Child(name string) *GeneralTracer {
+	// 请求结束后gin.Context会被复用，子tracer只持有请求的context
+	parent := context.WithValue(t.Ctx.Request.Context(), t.app.trace(), t.Tid)
+	return t.app.childTracer(parent, t.Tid, "", name, 0)
+}
+
+// ChildTimeout 创建带超时的子tracer
+func (t *GinTracer) ChildTimeout(name string, timeout time.Duration) *GeneralTracer {
+	parent := context.WithValue(t.Ctx.Request.Context(), t.app.trace(), t.Tid)
+	return t.app.childTracer(parent, t.Tid, "", name, timeout)
+}
+
+// Context 上下文，End 或父级取消后取消
+func (t *GeneralTracer) Context() context.Context {
+	return *t.Ctx
+}
+
+// TraceId 链路id
+func (t *GeneralTracer) TraceId() string {
+	return t.Tid
+}
+
+// Logger 带链路id的日志
+func (t *GeneralTracer) Logger() *zap.Logger {
+	return t.Log
+}
+
+// Mongo mongo客户端
+func (t *GeneralTracer) Mongo() *mongo.Client {
+	return t.Mdb
+}
+
+// RateLimiter 限流器
+func (t *GeneralTracer) RateLimiter() *RedisRate {
+	return t.Limiter
+}
+
+// Child 创建子tracer
+func (t *GeneralTracer) Child(name string) *GeneralTracer {
+	return t.app.childTracer(*t.Ctx, t.Tid, t.SpanId, name, 0)
+}
+
+// ChildTimeout 创建带超时的子tracer
+func (t *GeneralTracer) ChildTimeout(name string, timeout time.Duration) *GeneralTracer {
+	return t.app.childTracer(*t.Ctx, t.Tid, t.SpanId, name, timeout)
+}
+
+// End 结束span并取消上下文，可以重复调用
+func (t *GeneralTracer) End() {
+	if t.span != nil {
+		t.span.End()
+	}
+	if t.cancel != nil {
+		t.cancel()
+	}
+}
+
+/*
+childTracer 在parent下创建子tracer
+
+	开启链路追踪时span id与otel的span一致，否则随机生成
+	parentId为空时使用parent中otel span的id
+*/
+func (a *App) childTracer(parent context.Context, tid, parentId, name string, timeout time.Duration) *GeneralTracer {
+	if parentId == "" {
+		if sc := trace.SpanContextFromContext(parent); sc.IsValid() {
+			parentId = sc.SpanID().String()
+		}
+	}
+	c, span := a.tracer().Start(parent, name)
+	spanId := fmt.Sprintf("%016x", rand.Uint64())
+	if sc := span.SpanContext(); sc.IsValid() {
+		spanId = sc.SpanID().String()
+	}
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		c, cancel = context.WithTimeout(c, timeout)
+	} else {
+		c, cancel = context.WithCancel(c)
+	}
+	fields := []zap.Field{
+		zap.String(a.trace(), tid),
+		zap.String("span", name),
+		zap.String("span_id", spanId),
+	}
+	if parentId != "" {
+		fields = append(fields, zap.String("parent_id", parentId))
+	}
+	t := a.newGeneralTracer(c, cancel, tid, spanId, a.Log.With(fields...))
+	t.span = span
+	return t
+}
@@ -0,0 +1,354 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/sundaqiang/sdq-go/common"
+	"github.com/valyala/fasthttp"
+	"go.mongodb.org/mongo-driver/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"net/http"
+	"os"
+	"sync"
+)
+
+/*
+Tracing 链路追踪配置，对应 [tracing]
+
+	exporter为otlp时通过http发送到endpoint，为空时使用 OTEL_EXPORTER_OTLP_ENDPOINT
+	exporter为stdout、file时输出json，用于本地测试
+*/
+type Tracing struct {
+	Exporter string            `toml:"exporter" default:"otlp" validate:"oneof=otlp stdout file"`
+	Endpoint string            `toml:"endpoint" validate:"omitempty,hostname_port"`
+	Insecure bool              `toml:"insecure"` // otlp使用http而不是https
+	Headers  map[string]string `toml:"headers" secret:"true"`
+	File     string            `toml:"file" validate:"required_if=Exporter file"`
+	Service  string            `toml:"service"`                                  // 服务名，默认server.name
+	Sample   float64           `toml:"sample" default:"1" validate:"gt=0,max=1"` // 根span的采样率，有上游时跟随上游
+}
+
+// tracerName 本库创建span使用的名称
+const tracerName = "github.com/sundaqiang/sdq-go/service"
+
+// propagator W3C traceparent和baggage
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+var noopTracer = noop.NewTracerProvider().Tracer(tracerName)
+
+// InitTracing 初始化默认实例的链路追踪
+func InitTracing(info *Tracing) error {
+	return withStd(func(a *App) error {
+		return a.InitTracing(info)
+	})
+}
+
+// InitTracing 初始化链路追踪，之后gin、gorm、redis、mongo和 FastResponse 都会创建span
+func (a *App) InitTracing(info *Tracing) error {
+	if info == nil {
+		return nil
+	}
+	exporter, err := newSpanExporter(info)
+	if err != nil {
+		return newInitError(SubsystemTracing, err)
+	}
+	name := info.Service
+	if server := a.Config().Server; name == "" && server != nil {
+		name = server.Name
+	}
+	if name == "" {
+		name = "app"
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", name))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(info.Sample))),
+	)
+	a.tracerProvider = tp
+	a.onClose(SubsystemTracing, tp.Shutdown)
+	a.Log.Info("链路追踪初始化成功", zap.String("exporter", info.Exporter))
+	return nil
+}
+
+// newSpanExporter 按配置创建导出器
+func newSpanExporter(info *Tracing) (sdktrace.SpanExporter, error) {
+	switch info.Exporter {
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "file":
+		f, err := os.OpenFile(info.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		return &fileExporter{SpanExporter: exporter, f: f}, nil
+	default:
+		var opts []otlptracehttp.Option
+		if info.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(info.Endpoint))
+		}
+		if info.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(info.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(info.Headers))
+		}
+		return otlptracehttp.New(context.Background(), opts...)
+	}
+}
+
+// fileExporter 关闭时一并关闭文件
+type fileExporter struct {
+	sdktrace.SpanExporter
+	f *os.File
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	return errors.Join(e.SpanExporter.Shutdown(ctx), e.f.Close())
+}
+
+// tracer 实例的tracer，未初始化链路追踪时不记录
+func (a *App) tracer() trace.Tracer {
+	if a.tracerProvider == nil {
+		return noopTracer
+	}
+	return a.tracerProvider.Tracer(tracerName)
+}
+
+// Tracer 实例的tracer，用于在业务代码中创建span
+func (a *App) Tracer() trace.Tracer {
+	return a.tracer()
+}
+
+/*
+traceId 从上下文中取链路id，gorm、redis、mongo和 FastResponse 的日志统一使用
+
+	依次尝试gin.Context（包括由它派生的context）的链路id请求头、以server.trace为键的context值、otel span的trace id
+*/
+func (a *App) traceId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	key := a.trace()
+	c, ok := ctx.(*gin.Context)
+	if !ok {
+		// 由gin.Context派生的context，如gorm和redis钩子中加了span的context
+		c, ok = ctx.Value(gin.ContextKey).(*gin.Context)
+	}
+	if ok && key != "" {
+		header := common.KebabString(key)
+		if id := c.GetHeader(header); id != "" {
+			return id
+		}
+		if id := c.Writer.Header().Get(header); id != "" {
+			return id
+		}
+	}
+	if key != "" {
+		if id, ok := ctx.Value(key).(string); ok && id != "" {
+			return id
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		return sc.TraceID().String()
+	}
+	return ""
+}
+
+// traceLogger 带上下文中链路id的日志
+func (a *App) traceLogger(ctx context.Context) *zap.Logger {
+	if id := a.traceId(ctx); id != "" {
+		return a.Log.With(zap.String(a.trace(), id))
+	}
+	return a.Log
+}
+
+// endSpan 记录错误并结束span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingMiddleware 从traceparent继续上游链路，span放入请求的context
+func tracingMiddleware(a *App) gin.HandlerFunc {
+	tracer := a.tracer()
+	return func(c *gin.Context) {
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+		route := c.FullPath()
+		name := c.Request.Method
+		if route != "" {
+			name += " " + route
+		}
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+				attribute.String("client.address", c.ClientIP()),
+			),
+		)
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		for _, e := range c.Errors {
+			span.RecordError(e.Err)
+		}
+	}
+}
+
+// fasthttpCarrier 把span写入fasthttp请求头
+type fasthttpCarrier struct {
+	h *fasthttp.RequestHeader
+}
+
+func (c fasthttpCarrier) Get(key string) string {
+	return string(c.h.Peek(key))
+}
+
+func (c fasthttpCarrier) Set(key, value string) {
+	c.h.Set(key, value)
+}
+
+func (c fasthttpCarrier) Keys() []string {
+	var keys []string
+	for k := range c.h.All() {
+		keys = append(keys, string(k))
+	}
+	return keys
+}
+
+// traceSpanKey gorm语句span的键
+const traceSpanKey = "sdq:trace_span"
+
+// gormTracing 为gorm语句创建span的插件
+type gormTracing struct {
+	app *App
+}
+
+func (p gormTracing) Name() string {
+	return "sdq:tracing"
+}
+
+func (p gormTracing) Initialize(db *gorm.DB) error {
+	before := func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			ctx := db.Statement.Context
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			ctx, span := p.app.tracer().Start(ctx, "gorm."+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("db.system", db.Dialector.Name()),
+					attribute.String("db.operation.name", operation),
+				),
+			)
+			db.Statement.Context = ctx
+			db.InstanceSet(traceSpanKey, span)
+		}
+	}
+	after := func(db *gorm.DB) {
+		v, ok := db.InstanceGet(traceSpanKey)
+		if !ok {
+			return
+		}
+		span, ok := v.(trace.Span)
+		if !ok {
+			return
+		}
+		span.SetAttributes(
+			attribute.String("db.collection.name", db.Statement.Table),
+			attribute.String("db.query.text", db.Statement.SQL.String()),
+			attribute.Int64("db.rows_affected", db.RowsAffected),
+		)
+		err := db.Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = nil
+		}
+		endSpan(span, err)
+	}
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:create").Register("sdq:tracing_before_create", before("create")),
+		cb.Create().After("gorm:create").Register("sdq:tracing_after_create", after),
+		cb.Query().Before("gorm:query").Register("sdq:tracing_before_query", before("query")),
+		cb.Query().After("gorm:query").Register("sdq:tracing_after_query", after),
+		cb.Update().Before("gorm:update").Register("sdq:tracing_before_update", before("update")),
+		cb.Update().After("gorm:update").Register("sdq:tracing_after_update", after),
+		cb.Delete().Before("gorm:delete").Register("sdq:tracing_before_delete", before("delete")),
+		cb.Delete().After("gorm:delete").Register("sdq:tracing_after_delete", after),
+		cb.Row().Before("gorm:row").Register("sdq:tracing_before_row", before("row")),
+		cb.Row().After("gorm:row").Register("sdq:tracing_after_row", after),
+		cb.Raw().Before("gorm:raw").Register("sdq:tracing_before_raw", before("raw")),
+		cb.Raw().After("gorm:raw").Register("sdq:tracing_after_raw", after),
+	)
+}
+
+// mongoMonitor 记录mongo命令的日志和span，按RequestID关联开始和结束事件
+type mongoMonitor struct {
+	app   *App
+	spans sync.Map
+}
+
+func (m *mongoMonitor) monitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			_, span := m.app.tracer().Start(ctx, "mongo."+evt.CommandName,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("db.system", "mongodb"),
+					attribute.String("db.namespace", evt.DatabaseName),
+					attribute.String("db.operation.name", evt.CommandName),
+					attribute.String("server.address", evt.ConnectionID),
+				),
+			)
+			m.spans.Store(evt.RequestID, span)
+		},
+		Succeeded: func(ctx context.Context, evt *event.CommandSucceededEvent) {
+			if span, ok := m.spans.LoadAndDelete(evt.RequestID); ok {
+				endSpan(span.(trace.Span), nil)
+			}
+			m.app.traceLogger(ctx).Debug("mongo_trace",
+				zap.String("db", evt.DatabaseName),
+				zap.String("cmd", evt.CommandName),
+				zap.Duration("latency", evt.Duration),
+			)
+		},
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			err := errors.New(evt.Failure)
+			if span, ok := m.spans.LoadAndDelete(evt.RequestID); ok {
+				endSpan(span.(trace.Span), err)
+			}
+			m.app.traceLogger(ctx).Error("mongo_trace",
+				zap.String("db", evt.DatabaseName),
+				zap.String("cmd", evt.CommandName),
+				zap.Duration("latency", evt.Duration),
+				zap.Error(err),
+			)
+		},
+	}
+}
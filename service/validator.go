@@ -13,6 +13,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 var defaultRegs = ValidatorReg{
@@ -60,8 +61,21 @@ type ValidatorTranslator struct {
 	Msg string
 }
 
-// InitTrans 初始化翻译器
-func initValidator(locale string) (err error) {
+var (
+	validatorOnce sync.Once
+	validatorErr  error
+)
+
+// initValidator 注册校验规则和翻译器，gin的校验器是进程级的，多个实例只初始化一次
+func initValidator(locale string) error {
+	validatorOnce.Do(func() {
+		validatorErr = registerValidator(locale)
+	})
+	return validatorErr
+}
+
+// registerValidator 初始化翻译器
+func registerValidator(locale string) (err error) {
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		for _, structs := range defaultRegs.Struct {
 			v.RegisterStructValidation(structs.Fn, structs.Types)
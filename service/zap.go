@@ -9,7 +9,11 @@ import (
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/natefinch/lumberjack.v2"
 	"io"
+	"net/http"
 	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -38,68 +42,249 @@ func getLogWriter(logger *lumberjack.Logger, stdOut bool) zapcore.WriteSyncer {
 	return zapcore.AddSync(ws)
 }
 
+/*
+AccessLog 请求日志的记录范围，对应 [server.middleware.access-log]
+
+	错误请求（状态码>=400或有c.Errors）总是记录，成功请求每sample个记录一个
+*/
+type AccessLog struct {
+	MaxBody       int      `toml:"max-body" default:"4096" validate:"min=-1"` // 记录的请求体和响应体最大字节数，超出部分截断，-1不记录
+	ContentTypes  []string `toml:"content-types" default:"application/json,application/x-www-form-urlencoded,text/plain"`
+	RedactHeaders []string `toml:"redact-headers" default:"Authorization,Cookie,Set-Cookie,X-Api-Key"`
+	RedactFields  []string `toml:"redact-fields" default:"password,token,secret"` // json任意层级和表单中的字段名，不区分大小写
+	Response      bool     `toml:"response"`                                      // 是否记录响应体
+	Sample        int      `toml:"sample" default:"1" validate:"min=1"`
+}
+
+// DefaultAccessLog 默认的请求日志配置
+func DefaultAccessLog() AccessLog {
+	var conf AccessLog
+	_ = common.StructDefault(&conf, "default")
+	return conf
+}
+
 // GinZapWithConfig returns a gin.HandlerFunc using configs
 func GinZapWithConfig(logger *zap.Logger, conf *ginzap.Config, trace string) gin.HandlerFunc {
+	return GinZapWithAccessLog(logger, conf, trace, DefaultAccessLog())
+}
+
+// GinZapWithAccessLog 按 AccessLog 控制请求体、请求头、响应体的记录和成功请求的采样，零值字段使用 DefaultAccessLog 的值
+func GinZapWithAccessLog(logger *zap.Logger, conf *ginzap.Config, trace string, access AccessLog) gin.HandlerFunc {
+	// 未指定脱敏字段时也按默认值脱敏，避免只开启响应体记录时泄露凭证
+	_ = common.StructDefault(&access, "default")
 	skipPaths := make(map[string]bool, len(conf.SkipPaths))
 	for _, path := range conf.SkipPaths {
 		skipPaths[path] = true
 	}
+	redactHeaders := make(map[string]bool, len(access.RedactHeaders))
+	for _, v := range access.RedactHeaders {
+		redactHeaders[http.CanonicalHeaderKey(v)] = true
+	}
+	redactFields := newBodyRedactor(access.RedactFields)
+	sample := uint64(max(access.Sample, 1))
+	var count atomic.Uint64
 
 	return func(c *gin.Context) {
 		start := time.Now()
 		// some evil middlewares modify this values
 		path := c.Request.URL.Path
 		query := c.Request.URL.RawQuery
+		if _, ok := skipPaths[path]; ok {
+			c.Next()
+			return
+		}
 		var body []byte
-		if c.Request.Body != nil {
-			body, _ = io.ReadAll(c.Request.Body)
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		truncated := false
+		if c.Request.Body != nil && access.MaxBody > 0 &&
+			logContentType(access.ContentTypes, c.ContentType()) {
+			// 只读取max-body字节，剩余部分及读取错误仍由处理函数从原请求体读取
+			head, _ := io.ReadAll(io.LimitReader(c.Request.Body, int64(access.MaxBody)+1))
+			c.Request.Body = readCloser{
+				Reader: io.MultiReader(bytes.NewReader(head), c.Request.Body),
+				Closer: c.Request.Body,
+			}
+			body = head
+			if len(head) > access.MaxBody {
+				body, truncated = head[:access.MaxBody], true
+			}
+		}
+		var resp *captureWriter
+		if access.Response && access.MaxBody > 0 {
+			resp = &captureWriter{ResponseWriter: c.Writer, max: access.MaxBody}
+			c.Writer = resp
 		}
 		c.Next()
+		if resp != nil {
+			c.Writer = resp.ResponseWriter
+		}
 
-		if _, ok := skipPaths[path]; !ok {
-			end := time.Now()
-			latency := end.Sub(start)
-			if conf.UTC {
-				end = end.UTC()
-			}
+		failed := c.Writer.Status() >= http.StatusBadRequest || len(c.Errors) > 0
+		if !failed && count.Add(1)%sample != 0 {
+			return
+		}
+		end := time.Now()
+		latency := end.Sub(start)
+		if conf.UTC {
+			end = end.UTC()
+		}
 
-			fields := []zapcore.Field{
-				zap.Int("status", c.Writer.Status()),
-				zap.String("method", c.Request.Method),
-				zap.String("path", path),
-				zap.String("query", query),
-				zap.String("ip", c.ClientIP()),
-				zap.Reflect("header", c.Request.Header),
-				zap.Duration("latency", latency),
+		header := make(http.Header, len(c.Request.Header))
+		for k, v := range c.Request.Header {
+			if redactHeaders[k] {
+				v = []string{secretMask}
 			}
-			// body
-			if body != nil {
-				if json.Valid(body) {
-					fields = append(fields, zap.Reflect("body", common.Json2Map(body)))
-				} else {
-					fields = append(fields, zap.ByteString("body", body))
-				}
-			}
-			// log request ID
-			if requestID := c.Writer.Header().Get(common.KebabString(trace)); requestID != "" {
-				fields = append(fields, zap.String(trace, requestID))
-			}
-			/*if conf.TimeFormat != "" {
-				fields = append(fields, zap.String("time", end.Format(conf.TimeFormat)))
-			}*/
-			if conf.Context != nil {
-				fields = append(fields, conf.Context(c)...)
+			header[k] = v
+		}
+		fields := []zapcore.Field{
+			zap.Int("status", c.Writer.Status()),
+			zap.String("method", c.Request.Method),
+			zap.String("path", path),
+			zap.String("query", query),
+			zap.String("ip", c.ClientIP()),
+			zap.Reflect("header", header),
+			zap.Duration("latency", latency),
+		}
+		// body
+		if body != nil {
+			fields = append(fields, logBody("body", body, truncated, redactFields))
+		}
+		// 压缩后的响应体不记录
+		if resp != nil && c.Writer.Header().Get("Content-Encoding") == "" &&
+			logContentType(access.ContentTypes, c.Writer.Header().Get("Content-Type")) {
+			fields = append(fields, logBody("response", resp.buf.Bytes(), resp.truncated, redactFields))
+		}
+		// log request ID
+		if requestID := c.Writer.Header().Get(common.KebabString(trace)); requestID != "" {
+			fields = append(fields, zap.String(trace, requestID))
+		}
+		/*if conf.TimeFormat != "" {
+			fields = append(fields, zap.String("time", end.Format(conf.TimeFormat)))
+		}*/
+		if conf.Context != nil {
+			fields = append(fields, conf.Context(c)...)
+		}
+
+		if len(c.Errors) > 0 {
+			// Append error field if this is an erroneous request.
+			for _, e := range c.Errors.Errors() {
+				logger.Error(e, fields...)
 			}
+		} else {
+			logger.Info("Handler入口打印", fields...)
+		}
+	}
+}
+
+// logContentType 是否记录该类型的请求体
+func logContentType(types []string, contentType string) bool {
+	contentType, _, _ = strings.Cut(contentType, ";")
+	contentType = strings.TrimSpace(contentType)
+	for _, v := range types {
+		if strings.EqualFold(v, contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyRedactor 请求体和响应体的字段脱敏
+type bodyRedactor struct {
+	fields map[string]bool
+	text   []*regexp.Regexp // 无法解析的json片段和表单中的字段
+}
+
+func newBodyRedactor(fields []string) *bodyRedactor {
+	r := &bodyRedactor{fields: make(map[string]bool, len(fields))}
+	if len(fields) == 0 {
+		return r
+	}
+	names := make([]string, len(fields))
+	for i, v := range fields {
+		r.fields[strings.ToLower(v)] = true
+		names[i] = regexp.QuoteMeta(v)
+	}
+	group := "(?:" + strings.Join(names, "|") + ")"
+	r.text = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)("` + group + `"\s*:\s*)(?:"(?:[^"\\]|\\.)*"?|[^,}\]\s]*)`),
+		regexp.MustCompile(`(?i)((?:^|&)` + group + `=)[^&]*`),
+	}
+	return r
+}
+
+// redactText 按正则脱敏，用于截断的json和表单
+func (r *bodyRedactor) redactText(body string) string {
+	for i, re := range r.text {
+		if i == 0 {
+			body = re.ReplaceAllString(body, `${1}"`+secretMask+`"`)
+		} else {
+			body = re.ReplaceAllString(body, "${1}"+secretMask)
+		}
+	}
+	return body
+}
 
-			if len(c.Errors) > 0 {
-				// Append error field if this is an erroneous request.
-				for _, e := range c.Errors.Errors() {
-					logger.Error(e, fields...)
-				}
-			} else {
-				logger.Info("Handler入口打印", fields...)
+// logBody 完整的json按字段脱敏后记录，其他按字符串脱敏后记录
+func logBody(key string, body []byte, truncated bool, redact *bodyRedactor) zapcore.Field {
+	if !truncated && json.Valid(body) {
+		var v any
+		if err := json.Unmarshal(body, &v); err == nil {
+			return zap.Any(key, redactJson(v, redact.fields))
+		}
+	}
+	text := redact.redactText(string(body))
+	if truncated {
+		text += "...(truncated)"
+	}
+	return zap.String(key, text)
+}
+
+// redactJson 按字段名脱敏
+func redactJson(v any, redact map[string]bool) any {
+	switch val := v.(type) {
+	case map[string]any:
+		for k, item := range val {
+			if redact[strings.ToLower(k)] {
+				val[k] = secretMask
+				continue
 			}
+			val[k] = redactJson(item, redact)
+		}
+	case []any:
+		for i, item := range val {
+			val[i] = redactJson(item, redact)
 		}
 	}
+	return v
+}
+
+// readCloser 读取Reader，关闭原请求体
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// captureWriter 记录响应体的前max字节
+type captureWriter struct {
+	gin.ResponseWriter
+	max       int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *captureWriter) capture(b []byte) {
+	if n := w.max - w.buf.Len(); n < len(b) {
+		b = b[:max(n, 0)]
+		w.truncated = true
+	}
+	w.buf.Write(b)
 }